@@ -5,15 +5,18 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"sync"
 	"time"
 
-	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
+	"github.com/noahhai/kube-vegeta/histogram"
+	"github.com/noahhai/kube-vegeta/loadbots"
+
 	vegeta "github.com/tsenart/vegeta/lib"
 )
 
@@ -22,38 +25,77 @@ var (
 	selector = flag.String("selector", "", "The label selector for pods")
 	useIP    = flag.Bool("use-ip", false, "Use IP for aggregation")
 	sleep    = flag.Duration("sleep", 5*time.Second, "The sleep period between aggregations")
+	window   = flag.String("window", "10s", "Which of the loadbots' live metrics to aggregate [1s|10s|cumulative]")
 
 	serveData = []byte{}
+	fleetData = []byte("{}")
 	lock      = sync.Mutex{}
 )
 
-func getData() []byte {
+func getData() ([]byte, []byte) {
 	lock.Lock()
 	defer lock.Unlock()
-	return serveData
+	return serveData, fleetData
 }
 
-func setData(data []byte) {
+func setData(data, fleet []byte) {
 	lock.Lock()
 	defer lock.Unlock()
 	serveData = data
+	fleetData = fleet
 }
 
+// serveHTTP handles GET / with each loadbot's metrics
 func serveHTTP(res http.ResponseWriter, req *http.Request) {
+	data, _ := getData()
 	res.Header().Set("Access-Control-Allow-Origin", "*")
 	res.WriteHeader(http.StatusOK)
-	res.Write(getData())
+	res.Write(data)
+}
+
+// serveFleet handles GET /fleet with the metrics of every loadbot combined
+func serveFleet(res http.ResponseWriter, req *http.Request) {
+	_, fleet := getData()
+	res.Header().Set("Access-Control-Allow-Origin", "*")
+	res.WriteHeader(http.StatusOK)
+	res.Write(fleet)
 }
 
 func main() {
 	flag.Parse()
+	if *window != "1s" && *window != "10s" && *window != "cumulative" {
+		log.Fatalf("unknown window '%s'", *window)
+	}
+	if *historySize < 1 {
+		log.Fatalf("history must keep at least one aggregation")
+	}
+	fleetHistory = newHistory(*historySize)
 
 	http.HandleFunc("/", serveHTTP)
+	http.HandleFunc("/fleet", serveFleet)
+	http.HandleFunc("/history", serveHistory)
+	http.HandleFunc("/events", serveEvents)
+	http.Handle("/metrics", promhttp.Handler())
 	go http.ListenAndServe(*addr, nil)
 
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatalf("Error creating config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Error client: %v", err)
+	}
+	registry := loadbots.NewRegistry(clientset, *selector)
+	registry.Start(make(chan struct{}))
+	if err := registry.WaitForSync(*sleep); err != nil {
+		// aggregations pick up the loadbots once they are listed
+		fmt.Printf("%v\n", err)
+	}
+
 	for {
 		start := time.Now()
-		loadData()
+		loadData(registry)
 		latency := time.Now().Sub(start)
 		if latency < *sleep {
 			time.Sleep(*sleep - latency)
@@ -72,34 +114,14 @@ func getField(obj map[string]interface{}, fields ...string) (interface{}, bool)
 	}
 	return nextObj, true
 }
-func loadData() error {
-
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		fmt.Printf("Error creating config: %v", err)
-		return err
-	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		fmt.Printf("Error client: %v", err)
-		return err
-	}
-	pods, err := clientset.CoreV1().Pods("").List(metav1.ListOptions{
-		LabelSelector: *selector,
-	})
+func loadData(registry *loadbots.Registry) error {
+	loadbots, err := registry.Loadbots()
 	if err != nil {
 		fmt.Printf("Error getting pods: %v", err)
 		return err
 	}
-	loadbots := []*corev1.Pod{}
-	for ix := range pods.Items {
-		pod := &pods.Items[ix]
-		if pod.Status.PodIP == "" {
-			continue
-		}
-		loadbots = append(loadbots, pod)
-	}
-	parts := []vegeta.Metrics{}
+	clientset := registry.Clientset()
+	parts := []windowMetrics{}
 	lock := sync.Mutex{}
 	wg := sync.WaitGroup{}
 	wg.Add(len(loadbots))
@@ -109,45 +131,144 @@ func loadData() error {
 			pod := loadbots[ix]
 			var data []byte
 			if *useIP {
-				url := "http://" + pod.Status.PodIP + ":8080/"
+				url := "http://" + pod.Status.PodIP + ":8080/live"
 				resp, err := http.Get(url)
 				if err != nil {
 					fmt.Printf("Error getting: %v\n", err)
 					return
 				}
 				defer resp.Body.Close()
+				if resp.StatusCode == http.StatusNotFound {
+					// loadbot hasn't attacked yet
+					return
+				}
 				if data, err = ioutil.ReadAll(resp.Body); err != nil {
 					fmt.Printf("Error reading: %v\n", err)
 					return
 				}
 			} else {
 				var err error
-				data, err = clientset.RESTClient().Get().AbsPath("/api/v1/namespaces/default/pods/" + pod.Name + ":8080/proxy/").DoRaw()
+				data, err = clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1/namespaces/" + pod.Namespace + "/pods/" + pod.Name + ":8080/proxy/live").DoRaw()
 				if err != nil {
 					fmt.Printf("Error proxying to pod: %v\n", err)
 					return
 				}
 			}
-			var metrics vegeta.Metrics
-			if err := json.Unmarshal(data, &metrics); err != nil {
+			var live liveMetrics
+			if err := json.Unmarshal(data, &live); err != nil {
 				fmt.Printf("Error decoding: %v\n", err)
 				return
 			}
+			metrics := live.pick(*window)
+			if metrics == nil {
+				return
+			}
 			lock.Lock()
 			defer lock.Unlock()
-			parts = append(parts, metrics)
+			parts = append(parts, *metrics)
 		}(ix)
 	}
 	wg.Wait()
-	data, err := json.Marshal(parts)
+	// each loadbot's metrics are served without their histogram, which is
+	// only needed to combine them
+	each := make([]vegeta.Metrics, 0, len(parts))
+	for _, part := range parts {
+		each = append(each, part.Metrics)
+	}
+	data, err := json.Marshal(each)
+	if err != nil {
+		fmt.Printf("Error marshaling: %v", err)
+	}
+	now := time.Now()
+	combined := fleetMetrics(parts)
+	fleetHistory.add(newHistoryPoint(now, combined, len(parts)))
+	exportFleet(combined, len(parts))
+	fleet, err := json.Marshal(combined)
 	if err != nil {
 		fmt.Printf("Error marshaling: %v", err)
 	}
-	setData(data)
+	setData(data, fleet)
+	events.publish(&snapshotEvent{Time: now, Loadbots: data, Fleet: fleet})
 	fmt.Printf("Updated.\n")
 	return nil
 }
 
-func discoverPodsForLabel(label string) []corev1.Pod {
-	return nil
+// liveMetrics are a loadbot's rolling metrics of its current or last attack
+type liveMetrics struct {
+	Last1s     *windowMetrics
+	Last10s    *windowMetrics
+	Cumulative *windowMetrics
+}
+
+// windowMetrics are a loadbot's metrics of a window with the histogram of
+// their latencies
+type windowMetrics struct {
+	vegeta.Metrics
+	LatencyHistogram histogram.Histogram `json:"latencyHistogram"`
+}
+
+func (l *liveMetrics) pick(window string) *windowMetrics {
+	switch window {
+	case "1s":
+		return l.Last1s
+	case "10s":
+		return l.Last10s
+	default:
+		return l.Cumulative
+	}
+}
+
+// fleetMetrics combines the loadbots' metrics in the same shape, with the
+// latency percentiles read off their merged histograms
+func fleetMetrics(parts []windowMetrics) *vegeta.Metrics {
+	f := &vegeta.Metrics{
+		StatusCodes: map[string]int{},
+		Errors:      []string{},
+	}
+	merged := histogram.Histogram{}
+	errors := map[string]bool{}
+	var successes float64
+	for ix := range parts {
+		m := &parts[ix]
+		if m.Requests == 0 {
+			continue
+		}
+		f.Requests += m.Requests
+		f.Rate += m.Rate
+		f.Throughput += m.Throughput
+		successes += m.Success * float64(m.Requests)
+		if m.Duration > f.Duration {
+			f.Duration = m.Duration
+		}
+		if m.Wait > f.Wait {
+			f.Wait = m.Wait
+		}
+		f.Latencies.Total += m.Latencies.Total
+		if m.Latencies.Max > f.Latencies.Max {
+			f.Latencies.Max = m.Latencies.Max
+		}
+		f.BytesIn.Total += m.BytesIn.Total
+		f.BytesOut.Total += m.BytesOut.Total
+		merged.Merge(&m.LatencyHistogram)
+		for code, n := range m.StatusCodes {
+			f.StatusCodes[code] += n
+		}
+		for _, e := range m.Errors {
+			if !errors[e] {
+				errors[e] = true
+				f.Errors = append(f.Errors, e)
+			}
+		}
+	}
+	if f.Requests == 0 {
+		return f
+	}
+	f.Success = successes / float64(f.Requests)
+	f.Latencies.Mean = f.Latencies.Total / time.Duration(f.Requests)
+	f.Latencies.P50 = merged.Quantile(0.50)
+	f.Latencies.P95 = merged.Quantile(0.95)
+	f.Latencies.P99 = merged.Quantile(0.99)
+	f.BytesIn.Mean = float64(f.BytesIn.Total) / float64(f.Requests)
+	f.BytesOut.Mean = float64(f.BytesOut.Total) / float64(f.Requests)
+	return f
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// how often an idle event stream gets a comment, so proxies keep it open
+const eventsKeepAlive = 15 * time.Second
+
+// snapshotEvent is pushed to subscribers after every aggregation
+type snapshotEvent struct {
+	Time     time.Time       `json:"time"`
+	Loadbots json.RawMessage `json:"loadbots"`
+	Fleet    json.RawMessage `json:"fleet"`
+}
+
+// broadcaster pushes each snapshot to every subscriber. A subscriber that is
+// slow to read misses snapshots rather than holding up the others, since
+// only the latest one matters.
+type broadcaster struct {
+	sync.Mutex
+	subscribers map[chan []byte]struct{}
+	last        []byte
+}
+
+var events = &broadcaster{subscribers: map[chan []byte]struct{}{}}
+
+// subscribe returns a channel of snapshots, starting with the latest
+func (b *broadcaster) subscribe() chan []byte {
+	b.Lock()
+	defer b.Unlock()
+	ch := make(chan []byte, 1)
+	if b.last != nil {
+		ch <- b.last
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *broadcaster) unsubscribe(ch chan []byte) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.subscribers, ch)
+}
+
+func (b *broadcaster) publish(event *snapshotEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("Error marshaling: %v", err)
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.last = data
+	for ch := range b.subscribers {
+		// replace a snapshot the subscriber hasn't read yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- data
+	}
+}
+
+// serveEvents handles GET /events with a stream of Server-Sent Events, one
+// per aggregation, so dashboards needn't poll
+func serveEvents(res http.ResponseWriter, req *http.Request) {
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		res.WriteHeader(http.StatusInternalServerError)
+		res.Write([]byte("streaming unsupported"))
+		return
+	}
+	res.Header().Set("Access-Control-Allow-Origin", "*")
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case data := <-ch:
+			fmt.Fprintf(res, "event: snapshot\ndata: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(res, ": keep-alive\n\n")
+		case <-req.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+var historySize = flag.Int("history", 720, "How many aggregations to keep for /history, one every --sleep")
+
+// historyPoint is the fleet's metrics from one aggregation
+type historyPoint struct {
+	Time      time.Time     `json:"time"`
+	Rate      float64       `json:"rate"`
+	Mean      time.Duration `json:"mean"`
+	P50       time.Duration `json:"p50"`
+	P95       time.Duration `json:"p95"`
+	P99       time.Duration `json:"p99"`
+	ErrorRate float64       `json:"errorRate"`
+	Loadbots  int           `json:"loadbots"`
+}
+
+func newHistoryPoint(at time.Time, fleet *vegeta.Metrics, loadbots int) historyPoint {
+	p := historyPoint{
+		Time:     at,
+		Rate:     fleet.Rate,
+		Mean:     fleet.Latencies.Mean,
+		P50:      fleet.Latencies.P50,
+		P95:      fleet.Latencies.P95,
+		P99:      fleet.Latencies.P99,
+		Loadbots: loadbots,
+	}
+	if fleet.Requests > 0 {
+		p.ErrorRate = 1 - fleet.Success
+	}
+	return p
+}
+
+// history keeps the latest points in a ring so its memory stays bounded
+// however long the aggregator runs
+type history struct {
+	sync.Mutex
+	points []historyPoint
+	// next is where the next point goes, over the oldest once full
+	next int
+	full bool
+}
+
+var fleetHistory *history
+
+func newHistory(size int) *history {
+	return &history{points: make([]historyPoint, size)}
+}
+
+func (h *history) add(p historyPoint) {
+	h.Lock()
+	defer h.Unlock()
+	h.points[h.next] = p
+	h.next = (h.next + 1) % len(h.points)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// since returns the points after t, oldest first
+func (h *history) since(t time.Time) []historyPoint {
+	h.Lock()
+	defer h.Unlock()
+	ordered := h.points[:h.next]
+	if h.full {
+		ordered = append(append([]historyPoint{}, h.points[h.next:]...), ordered...)
+	}
+	points := []historyPoint{}
+	for _, p := range ordered {
+		if p.Time.After(t) {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// serveHistory handles GET /history?since= with the points after the RFC 3339
+// time, or every point kept if it is left out
+func serveHistory(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Access-Control-Allow-Origin", "*")
+	var since time.Time
+	if value := req.URL.Query().Get("since"); value != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			res.Write([]byte("since must be an RFC 3339 time: " + err.Error()))
+			return
+		}
+	}
+	data, err := json.Marshal(fleetHistory.since(since))
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		res.Write([]byte("Error marshaling: " + err.Error()))
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(data)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// the fleet's metrics from the latest aggregation, over the loadbots' --window
+var (
+	fleetLoadbots = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "loadtest",
+		Subsystem: "aggregator",
+		Name:      "fleet_loadbots",
+		Help:      "Loadbots whose metrics were aggregated.",
+	})
+	fleetRate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "loadtest",
+		Subsystem: "aggregator",
+		Name:      "fleet_requests_per_second",
+		Help:      "Requests per second sent by every loadbot together.",
+	})
+	fleetSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "loadtest",
+		Subsystem: "aggregator",
+		Name:      "fleet_success_ratio",
+		Help:      "Share of the fleet's requests that succeeded.",
+	})
+	fleetLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "loadtest",
+		Subsystem: "aggregator",
+		Name:      "fleet_latency_seconds",
+		Help:      "Latency percentiles of the fleet's requests, read off the loadbots' merged histograms.",
+	}, []string{"quantile"})
+	fleetMeanLatency = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "loadtest",
+		Subsystem: "aggregator",
+		Name:      "fleet_latency_mean_seconds",
+		Help:      "Mean latency of the fleet's requests.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(fleetLoadbots, fleetRate, fleetSuccess, fleetLatency, fleetMeanLatency)
+}
+
+func exportFleet(fleet *vegeta.Metrics, loadbots int) {
+	fleetLoadbots.Set(float64(loadbots))
+	fleetRate.Set(fleet.Rate)
+	fleetSuccess.Set(fleet.Success)
+	fleetMeanLatency.Set(fleet.Latencies.Mean.Seconds())
+	fleetLatency.WithLabelValues("0.5").Set(fleet.Latencies.P50.Seconds())
+	fleetLatency.WithLabelValues("0.95").Set(fleet.Latencies.P95.Seconds())
+	fleetLatency.WithLabelValues("0.99").Set(fleet.Latencies.P99.Seconds())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"time"
+
+	"github.com/noahhai/kube-vegeta/histogram"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// fleetMetrics combines the metrics of every loadbot in a run
+type fleetMetrics struct {
+	Requests    uint64
+	Rate        float64
+	Throughput  float64
+	Success     float64
+	Duration    time.Duration
+	Total       time.Duration
+	Mean        time.Duration
+	P50         time.Duration
+	P95         time.Duration
+	P99         time.Duration
+	Max         time.Duration
+	StatusCodes map[string]int
+	Errors      []string
+}
+
+// histogramMetrics are a loadbot's metrics with the histogram of their
+// latencies
+type histogramMetrics struct {
+	vegeta.Metrics
+	LatencyHistogram histogram.Histogram `json:"latencyHistogram"`
+}
+
+// aggregateMetrics combines loadbots' metrics. Percentiles are read off their
+// merged histograms, or averaged if a loadbot too old to send its histogram
+// took part, which only approximates them.
+func aggregateMetrics(metrics []histogramMetrics) *fleetMetrics {
+	f := &fleetMetrics{
+		StatusCodes: map[string]int{},
+		Errors:      []string{},
+	}
+	merged := histogram.Histogram{}
+	mergeable := true
+	var p50, p95, p99, successes float64
+	for _, m := range metrics {
+		if m.Requests == 0 {
+			continue
+		}
+		f.Requests += m.Requests
+		if f.Duration == 0 {
+			f.Duration = m.Duration
+		}
+		f.Total += m.Latencies.Total
+		if m.Latencies.Max > f.Max {
+			f.Max = m.Latencies.Max
+		}
+		merged.Merge(&m.LatencyHistogram)
+		mergeable = mergeable && m.LatencyHistogram.Count > 0
+		fractionThis := float64(m.Requests) / float64(f.Requests)
+		fractionRest := 1.0 - fractionThis
+		p50 = fractionThis*float64(m.Latencies.P50) + fractionRest*p50
+		p95 = fractionThis*float64(m.Latencies.P95) + fractionRest*p95
+		p99 = fractionThis*float64(m.Latencies.P99) + fractionRest*p99
+
+		f.Rate += m.Rate
+		f.Throughput += m.Throughput
+		successes += m.Success * float64(m.Requests)
+
+		f.Errors = append(f.Errors, m.Errors...)
+		for k, v := range m.StatusCodes {
+			f.StatusCodes[k] += v
+		}
+	}
+	if f.Requests == 0 {
+		return f
+	}
+	f.Mean = f.Total / time.Duration(f.Requests)
+	f.Success = successes / float64(f.Requests)
+	if mergeable {
+		f.P50 = merged.Quantile(0.50)
+		f.P95 = merged.Quantile(0.95)
+		f.P99 = merged.Quantile(0.99)
+	} else {
+		f.P50 = time.Duration(p50)
+		f.P95 = time.Duration(p95)
+		f.P99 = time.Duration(p99)
+	}
+	return f
+}
+
+// overallMetrics pulls each loadbot's metrics for the whole attack out of the results
+func overallMetrics(results []loaderMetrics) []histogramMetrics {
+	all := make([]histogramMetrics, 0, len(results))
+	for _, r := range results {
+		all = append(all, r.histogramMetrics)
+	}
+	return all
+}
@@ -1,4 +1,3 @@
-// TODO : make this concurrency safe when run in serve mode; spin a context off of the global flags
 package main
 
 import (
@@ -6,24 +5,30 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/joncalhoun/qson"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
-	"github.com/icrowley/fake"
 	flag "github.com/spf13/pflag"
 )
 
-var allCommands SyncCommandSet
 var currDir string
-var binaryName string
-var foundCli bool
+var cliLock sync.Mutex
+
+// cliBinaries are the binaries found or downloaded so far by requested
+// version, "" meaning whichever is installed
+var cliBinaries = map[string]string{}
 var defaultCliVersion = "0.1.1"
 
 var (
@@ -33,7 +38,6 @@ var (
 	tenant            = flag.StringP("tenant", "t", "", "Tenant name to create")
 	adminEndpoint     = flag.StringP("admin-endpoint", "a", "https://7h1u0s6a44.execute-api.us-east-1.amazonaws.com/Prod", "Admin Endpoint. Default is QA")
 	adminUser         = flag.String("admin-user", "admin", "Admin user for tenant")
-	adminPassword     string
 	domain            = flag.StringP("domain", "d", "qabambe.com", "Tenant domain. Default is qabambe.com")
 	operation         = flag.StringP("operation", "o", "", "Operation to conduct [setup|teardown|test|full]")
 	numberUsers       = flag.IntP("users", "u", 10, "Number of users to create")
@@ -47,22 +51,67 @@ var (
 
 func main() {
 	onStartup()
-	if err := validateCmd(true); err != nil {
-		failOnCli(err.Error())
-	}
 
 	if *serve {
+		jobSlots = make(chan struct{}, *maxJobs)
 		http.HandleFunc("/command", serveFunc)
+		http.HandleFunc("/jobs", serveJobs)
+		http.HandleFunc("/jobs/", serveJobs)
+		http.HandleFunc("/runs", serveRuns)
+		http.HandleFunc("/runs/", serveRuns)
+		http.HandleFunc("/targets", serveTargets)
+		http.HandleFunc("/targets/", serveTargets)
+		http.Handle("/metrics", promhttp.Handler())
 		log.Printf("starting to serve on port %d\n", *port)
 		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
 		return
 	}
 
-	if err := ensureCliDownloaded(); err != nil {
+	run := newRunConfig()
+	if *scenarioFile != "" {
+		sc, err := loadScenarioFile(*scenarioFile)
+		if err != nil {
+			failOnCli(err.Error())
+		}
+		sc.Apply(run)
+		applyExplicitFlags(run)
+	}
+	if *targetsFile != "" {
+		run.targetsFile = *targetsFile
+	}
+	if run.targetsFile != "" {
+		id, err := importTargetsFile(run.targetsFile, *targetsFormat)
+		if err != nil {
+			failOnCli(err.Error())
+		}
+		run.Targets = id
+	}
+	if *templatesFile != "" {
+		templates, err := loadTemplatesFile(*templatesFile)
+		if err != nil {
+			failOnCli(err.Error())
+		}
+		run.Templates = templates
+	}
+	if err := validateCmd(run); err != nil {
+		failOnCli(err.Error())
+	}
+	if err := run.ensureCli(); err != nil {
 		os.Exit(1)
 	}
-	preRun()
-	status, res := runTasks()
+	preRun(run)
+
+	// first interrupt cancels the run (stopping any loadbots), the second exits
+	interrupts := make(chan os.Signal, 2)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		fmt.Println("interrupted. cancelling run, interrupt again to exit immediately")
+		run.cancel()
+		<-interrupts
+		os.Exit(1)
+	}()
+	status, res := runTasks(run)
 	if res != nil && len(res) > 0 {
 		fmt.Println("output:")
 		fmt.Println(string(res))
@@ -73,15 +122,15 @@ func main() {
 func onStartup() {
 	flag.Parse()
 	currDir, _ = os.Getwd()
-}
+	// TODO : figure out why DNS resolution of pods isnt working
+	*useIP = true
 
-func preRun() {
-	// TODO : update this as we change it
-	adminPassword = *adminUser + "@1" + *adminUser + "@1"
-	*tenant = strings.ToLower(*tenant)
-	if *tenant == "" {
-		*tenant = strings.Replace(strings.ToLower(fake.Company()), " ", "-", -1)
-		fmt.Println("blank tenant name. generated random: " + *tenant)
+	var err error
+	if models, err = newModelStore(*modelStoreType); err != nil {
+		log.Fatalf("failed to create model store: %v\n", err)
+	}
+	if runs, err = newRunStore(*runStoreType); err != nil {
+		log.Fatalf("failed to create run store: %v\n", err)
 	}
 }
 
@@ -96,27 +145,69 @@ func failOnServer(err string) error {
 	return errors.New(err)
 }
 
-func validateCmd(ignoreServe bool) error {
+func validateCmd(run *runConfig) error {
 	var errMsg string
-	if ignoreServe && *serve {
-		return nil
-	}
-	if *operation != "setup" && *operation != "teardown" && *operation != "test" && *operation != "full" {
-		errMsg = fmt.Sprintf("error: operation flag did not match a valid operation. Value: '%s'\n", *operation)
+	if run.Operation != "setup" && run.Operation != "teardown" && run.Operation != "test" && run.Operation != "full" {
+		errMsg = fmt.Sprintf("error: operation flag did not match a valid operation. Value: '%s'\n", run.Operation)
 
-	} else if *operation == "teardown" && *tenant == "" {
+	} else if (run.Operation == "teardown" || (run.Operation == "test" && run.Targets == "" && len(run.Templates) == 0 && run.GRPC == nil)) && run.Tenant == "" {
 		errMsg = "error: must specify tenant"
 	}
-	if *loadDuration > 3000 {
-		errMsg = "error: --load-duration has max of 3000 seconds"
+	if run.Targets != "" {
+		if _, err := os.Stat(targetsPath(run.Targets)); err != nil {
+			errMsg = fmt.Sprintf("error: no targets with id '%s'", run.Targets)
+		}
 	}
-	if errMsg != "" {
-		if *serve {
-			return failOnServer(errMsg)
+	if run.Executor != "cli" && run.Executor != "http" {
+		errMsg = fmt.Sprintf("error: executor did not match a valid executor. Value: '%s'\n", run.Executor)
+	}
+	if err := validSelector(run.Selector); err != nil {
+		errMsg = "error: " + err.Error()
+	}
+	if len(run.LoadPhases) > 0 {
+		if err := validatePhases(run.LoadPhases); err != nil {
+			errMsg = "error: " + err.Error()
 		} else {
-			return failOnCli(errMsg)
+			// the profile decides how long the test runs
+			run.LoadDuration = phasesDuration(run.LoadPhases)
 		}
 	}
+	if problems := run.Thresholds.validate(); len(problems) > 0 {
+		errMsg = "error: invalid thresholds: " + strings.Join(problems, ", ")
+	}
+	if problems := validateTemplates(run.Templates); len(problems) > 0 {
+		errMsg = "error: invalid templates: " + strings.Join(problems, ", ")
+	}
+	if run.Targets != "" && len(run.Templates) > 0 {
+		errMsg = "error: use either targets or templates, not both"
+	}
+	if problems := run.GRPC.validate(); len(problems) > 0 {
+		errMsg = "error: invalid grpc: " + strings.Join(problems, ", ")
+	}
+	if run.GRPC != nil && (run.Targets != "" || len(run.Templates) > 0 || run.VirtualUsers > 0) {
+		errMsg = "error: grpc can't be combined with targets, templates or virtual users"
+	}
+	if problems := validateWorkload(run.Workload); len(problems) > 0 {
+		errMsg = "error: invalid workload: " + strings.Join(problems, ", ")
+	}
+	if run.VirtualUsers < 0 || run.ThinkTime < 0 || run.ThinkJitter < 0 {
+		errMsg = "error: virtual users and think times must not be negative"
+	}
+	if run.VirtualUsers > 0 && len(run.LoadPhases) > 0 {
+		errMsg = "error: virtual users can't follow load phases"
+	}
+	if run.BodySize < 0 {
+		errMsg = "error: --body-size must not be negative"
+	}
+	if run.Tolerances.Latency < 0 || run.Tolerances.Success < 0 || run.Tolerances.Throughput < 0 {
+		errMsg = "error: tolerances must not be negative"
+	}
+	if run.LoadDuration > 3000 {
+		errMsg = "error: --load-duration has max of 3000 seconds"
+	}
+	if errMsg != "" {
+		return errors.New(errMsg)
+	}
 	return nil
 }
 
@@ -125,41 +216,48 @@ func respFromError(err error) (status int, resp []byte, model *postLoaderModel)
 	return 1, m, nil
 }
 
-func taskSetup() (status int, resp []byte, testModel *postLoaderModel) {
+func taskSetup(run *runConfig) (status int, resp []byte, testModel *postLoaderModel) {
 	log.Println("---Starting setup task")
-	err := createRemoteTenant()
+	err := createRemoteTenant(run)
 	if err != nil {
 		fmt.Println("failed to create tenant")
 		return respFromError(err)
 	}
 	model := &postLoaderModel{
-		Tenant:   *tenant,
-		Domain:   *domain,
-		Rate:     *loadRate,
-		Duration: *loadDuration,
+		Tenant:   run.Tenant,
+		Domain:   run.Domain,
+		Rate:     run.LoadRate,
+		Duration: run.LoadDuration,
+		Phases:   run.LoadPhases,
 		// TODO : number workers
 	}
 
-	secretPaths := prepareDataLocally()
+	secretPaths := prepareDataLocally(run)
 	model.SecretPaths = secretPaths
 
-	tokens, err := populateRemoteTenant()
+	if err := run.prepareCliConfig(); err != nil {
+		return respFromError(err)
+	}
+	defer run.cleanupCliConfig()
+	tokens, err := populateRemoteTenant(run)
 	if err != nil {
 		fmt.Println(err)
 		return respFromError(err)
 	}
 	if len(tokens) > 0 {
-		model.Tokens = tokens
+		model.Tokens = accessTokens(tokens)
+		model.Credentials = tokens
+		model.TokenURL = tokenURL(run)
 	}
 
 	log.Println("---Finished setup task")
-	resp, _ = json.Marshal(map[string]string{"tenant": *tenant})
+	resp, _ = json.Marshal(map[string]string{"tenant": run.Tenant})
 	return 0, resp, model
 }
 
-func taskTeardown() error {
+func taskTeardown(run *runConfig) error {
 	log.Println("---Starting teardown task")
-	err := DoTeardown()
+	err := DoTeardown(run)
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -168,51 +266,99 @@ func taskTeardown() error {
 	return nil
 }
 
-func runTasks() (status int, resp []byte) {
+func runTasks(run *runConfig) (status int, resp []byte) {
 	status = 0
 	resp = []byte{}
 	var testModel *postLoaderModel
-	doAll := *operation == "full"
-	if doAll || *operation == "setup" {
-		status, resp, testModel = taskSetup()
-		if status != 0 {
+	doAll := run.Operation == "full"
+	if doAll || run.Operation == "setup" {
+		run.setState(jobSetup)
+		status, resp, testModel = taskSetup(run)
+		// a full run cancelled during setup still tears down what was created
+		if status != 0 && !(doAll && run.cancelled()) {
 			return status, resp
 		}
-		saveTestModel(testModel.Tenant, testModel)
+		if testModel != nil {
+			saveTestModel(testModel.Tenant, testModel)
+		}
 	}
-	if status == 0 && (doAll || *operation == "test") {
+	if status == 0 && !run.cancelled() && (doAll || run.Operation == "test") {
 		if testModel == nil {
-			testModel = getTestModel(*tenant)
+			testModel = getTestModel(run.Tenant)
+			if testModel != nil {
+				// a stored tenant can be tested at whatever rate this run asks for
+				testModel.Rate = run.LoadRate
+				testModel.Duration = run.LoadDuration
+				testModel.Phases = run.LoadPhases
+			}
+		}
+		if testModel == nil && (run.Targets != "" || len(run.Templates) > 0 || run.GRPC != nil) {
+			// replayed targets carry everything they need, and templates
+			// and grpc calls without a stored tenant just go without paths
+			// and tokens
+			testModel = &postLoaderModel{
+				Tenant:   run.Tenant,
+				Domain:   run.Domain,
+				Rate:     run.LoadRate,
+				Duration: run.LoadDuration,
+				Phases:   run.LoadPhases,
+			}
 		}
 		if testModel == nil {
-			return 1, []byte("failed to load test model for tenant: " + *tenant)
+			return 1, []byte("failed to load test model for tenant: " + run.Tenant)
 		}
-		s, r := taskLoadtest(testModel)
+		run.setState(jobTesting)
+		s, r := taskLoadtest(run, testModel)
 		status |= s
 		resp = r
+		if run.job != nil {
+			run.job.setResult(resp)
+		}
 	}
-	if doAll || *operation == "teardown" {
-		if err := taskTeardown(); err != nil {
+	if doAll || run.Operation == "teardown" {
+		run.setState(jobTeardown)
+		if err := taskTeardown(run); err != nil {
 			status = 1
 			resp = []byte(err.Error())
+		} else {
+			deleteTestModel(run.Tenant)
 		}
 	}
 	return status, resp
 }
 
-// TODO : implement these and we can run "test" as standalone command
 func saveTestModel(tenant string, model *postLoaderModel) {
-
+	if err := models.Save(tenant, model); err != nil {
+		fmt.Printf("failed to save test model for tenant %s: %v\n", tenant, err)
+	}
 }
+
 func getTestModel(tenant string) *postLoaderModel {
-	return nil
+	model, err := models.Load(tenant)
+	if err != nil {
+		fmt.Printf("failed to load test model for tenant %s: %v\n", tenant, err)
+		return nil
+	}
+	return model
+}
+
+func deleteTestModel(tenant string) {
+	if err := models.Delete(tenant); err != nil {
+		fmt.Printf("failed to delete test model for tenant %s: %v\n", tenant, err)
+	}
 }
 
 func serveFunc(w http.ResponseWriter, r *http.Request) {
 	fmt.Println(r.RequestURI)
 	var params argsModel
+	var sc *scenario
 	var err error
-	if r.Method == "POST" {
+	if r.Method == "POST" && strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		var body []byte
+		if body, err = ioutil.ReadAll(r.Body); err == nil {
+			sc, err = parseScenario(body)
+		}
+	} else if r.Method == "POST" {
 		decoder := json.NewDecoder(r.Body)
 		err = decoder.Decode(&params)
 	} else {
@@ -226,42 +372,50 @@ func serveFunc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	params.Apply()
-	if err := validateCmd(false); err != nil {
+	run := newRunConfig()
+	if sc != nil {
+		// answered in the redash format unless it asks for json, like the
+		// requests without a scenario
+		run.Redash = true
+		sc.Apply(run)
+		params.Tenant = sc.Tenant
+	} else {
+		params.Apply(run)
+	}
+	if run.targetsFile != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error: load.targets.file only works on the cli. POST the file to /targets and use its id"))
+		return
+	}
+	if err := validateCmd(run); err != nil {
+		failOnServer(err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Error: " + err.Error()))
 		return
 	}
 	if params.Tenant == "" {
 		// fresh tenant every time unless specified
-		*tenant = strings.Replace(strings.ToLower(fake.Company()), " ", "-", -1)
-		fmt.Println("Updating tenant name to: " + *tenant)
+		run.Tenant = randomTenantName()
+		fmt.Println("Updating tenant name to: " + run.Tenant)
 	}
-	preRun()
+	preRun(run)
 
-	// validation
-	if *operation == "teardown" && *tenant == "" {
-		log.Printf("must specify tenant name for teardown")
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	if err := ensureCliDownloaded(); err != nil {
-		w.Write([]byte(err.Error()))
-		w.WriteHeader(http.StatusBadRequest)
+	fmt.Println("operation: " + run.Operation)
+	j := startJob(run)
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	if r.URL.Query().Get("wait") != "true" {
+		writeJSON(w, http.StatusAccepted, j)
 		return
 	}
 
-	fmt.Println("operation: " + *operation)
-	status, resp := runTasks()
-	if resp != nil && len(resp) > 0 {
-		w.Write(resp)
-	}
-	if status == 1 {
-		w.WriteHeader(http.StatusInternalServerError)
-	} else {
-		w.WriteHeader(http.StatusOK)
+	// block until the run finishes so callers like ci pipelines can gate on
+	// the status alone
+	select {
+	case <-j.done:
+	case <-r.Context().Done():
+		return
 	}
-
+	writeJSON(w, j.httpStatus(), j)
 }
 
 func logAndError(err string) error {
@@ -270,91 +424,113 @@ func logAndError(err string) error {
 	return errors.New(err)
 }
 
-func ensureCliDownloaded() error {
-	if foundCli {
-		return nil
+// ensureCliDownloaded makes sure the requested cli version is available and
+// returns the binary to execute. Each version is kept in its own file,
+// thy-<version>, so runs asking for different versions never share or
+// overwrite a binary. Lookups are serialized so a version downloads once.
+func ensureCliDownloaded(cliVersionLocal string) (string, error) {
+	cliLock.Lock()
+	defer cliLock.Unlock()
+	if binary, ok := cliBinaries[cliVersionLocal]; ok {
+		return binary, nil
 	}
-	cliVersionLocal := *cliVersion
 	fmt.Println("checking for vault cli")
 
+	execSuffix := ""
 	if runtime.GOOS == "windows" {
-		binaryName = "thy.exe"
-	} else {
-		binaryName = "thy"
+		execSuffix = ".exe"
 	}
 
-	if cliVersionLocal == "" {
-		// check current directory
-
-		if _, err := os.Stat(path.Join(currDir, binaryName)); os.IsNotExist(err) {
-			log.Println("cli not found in current directory: " + path.Join(currDir, binaryName))
-		} else if err != nil {
-			log.Println("error checking for cli in " + path.Join(currDir, binaryName) + ". Error: " + err.Error())
-		} else {
+	version := cliVersionLocal
+	if version == "" {
+		// check current directory, then path
+		name := "thy" + execSuffix
+		if _, err := os.Stat(path.Join(currDir, name)); err == nil {
 			log.Println("cli version not specified and found cli in current directory")
-			foundCli = true
-		}
-		if !foundCli {
-			// check in path
-			_, err := exec.LookPath(binaryName)
-			if err == nil {
-				fmt.Println("cli version not specified and found cli in path")
-				return nil
-			} else {
-				fmt.Println("cli not found in path")
-			}
-		}
-
-	}
-	if cliVersionLocal != "" || !foundCli {
-		if cliVersionLocal == "" {
-			cliVersionLocal = defaultCliVersion
-		}
-		fmt.Println("cli version specified or not found locally. downloading version: " + cliVersionLocal)
-		execPath := path.Join(currDir, binaryName)
-		out, err := os.Create(execPath)
-		if err != nil {
-			return logAndError(fmt.Sprintf("error creating file for cli: %v", err))
-		}
-		defer out.Close()
-		var execSuffix, bitness, osName string
-		osName = runtime.GOOS
-		if runtime.GOARCH == "amd64" || false {
-			bitness = "x64"
+			cliBinaries[""] = localBinary(name)
+			return cliBinaries[""], nil
+		} else if os.IsNotExist(err) {
+			log.Println("cli not found in current directory: " + path.Join(currDir, name))
 		} else {
-			bitness = "x86"
+			log.Println("error checking for cli in " + path.Join(currDir, name) + ". Error: " + err.Error())
 		}
-		if osName == "windows" {
-			execSuffix = ".exe"
-			osName = ""
-		} else {
-			osName = "-" + osName
+		if _, err := exec.LookPath(name); err == nil {
+			fmt.Println("cli version not specified and found cli in path")
+			cliBinaries[""] = name
+			return name, nil
 		}
+		fmt.Println("cli not found in path")
+		version = defaultCliVersion
+	}
 
-		cliUrl := fmt.Sprintf("https://cli.qabambe.com/cli/%s/thy-%s%s-%s%s", cliVersionLocal, cliVersionLocal, osName, bitness, execSuffix)
-		fmt.Println("downloading cli from: " + cliUrl)
-		resp, err := http.Get(cliUrl)
-		if err != nil {
-			return logAndError(fmt.Sprintf("failed to fetch cli at %s. Error: \n%v\n", cliUrl, err))
-		}
-		defer resp.Body.Close()
-		_, err = io.Copy(out, resp.Body)
-		if err != nil {
-			return logAndError(fmt.Sprintf("failed to create cli file: %v", err))
-		}
-		err = os.Chmod(execPath, 0777)
-		if err != nil {
-			return logAndError(fmt.Sprintf("failed to set cli permissions to 0777: %v", err))
+	name := fmt.Sprintf("thy-%s%s", version, execSuffix)
+	execPath := path.Join(currDir, name)
+	if _, err := os.Stat(execPath); err != nil {
+		if err := downloadCli(version, execPath); err != nil {
+			return "", err
 		}
+	} else {
+		log.Println("found cli version " + version + " in current directory")
 	}
+	cliBinaries[cliVersionLocal] = localBinary(name)
+	return cliBinaries[cliVersionLocal], nil
+}
 
-	// if here we should use cli in working directory
+// localBinary is how to execute name from the working directory
+func localBinary(name string) string {
 	if runtime.GOOS == "windows" {
-		binaryName = ".\\" + binaryName
+		return ".\\" + name
+	}
+	return "./" + name
+}
+
+// downloadCli fetches the cli version into execPath. It downloads to a
+// temporary file first so a partial download is never left at execPath.
+func downloadCli(version, execPath string) error {
+	fmt.Println("cli version specified or not found locally. downloading version: " + version)
+	var execSuffix, bitness, osName string
+	osName = runtime.GOOS
+	if runtime.GOARCH == "amd64" || false {
+		bitness = "x64"
 	} else {
-		binaryName = "./" + binaryName
+		bitness = "x86"
+	}
+	if osName == "windows" {
+		execSuffix = ".exe"
+		osName = ""
+	} else {
+		osName = "-" + osName
 	}
 
+	cliUrl := fmt.Sprintf("https://cli.qabambe.com/cli/%s/thy-%s%s-%s%s", version, version, osName, bitness, execSuffix)
+	fmt.Println("downloading cli from: " + cliUrl)
+	resp, err := http.Get(cliUrl)
+	if err != nil {
+		return logAndError(fmt.Sprintf("failed to fetch cli at %s. Error: \n%v\n", cliUrl, err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return logAndError(fmt.Sprintf("failed to fetch cli at %s: status %d", cliUrl, resp.StatusCode))
+	}
+	out, err := ioutil.TempFile(currDir, path.Base(execPath)+".download")
+	if err != nil {
+		return logAndError(fmt.Sprintf("error creating file for cli: %v", err))
+	}
+	defer os.Remove(out.Name())
+	_, err = io.Copy(out, resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return logAndError(fmt.Sprintf("failed to create cli file: %v", err))
+	}
+	err = os.Chmod(out.Name(), 0777)
+	if err != nil {
+		return logAndError(fmt.Sprintf("failed to set cli permissions to 0777: %v", err))
+	}
+	if err = os.Rename(out.Name(), execPath); err != nil {
+		return logAndError(fmt.Sprintf("failed to move cli into place: %v", err))
+	}
 	return nil
 }
 
@@ -371,50 +547,129 @@ type argsModel struct {
 	NumberPermissions int
 	SecretLength      int
 	CliVersion        string
+	Executor          string
 	LoadDuration      int
 	LoadRate          int
+	LoadPhases        []loadPhase
+	Thresholds        *thresholds
+	Scenario          string
+	Baseline          string
+	SaveBaseline      string
+	FailOnRegression  *bool
+	Tolerances        *toleranceOverrides
+	RecordResults     *bool
+	Workload          map[string]int
+	BodySize          int
+	Targets           string
+	LoopTargets       *bool
+	RandomTargets     *bool
+	Templates         []targetTemplate
+	VirtualUsers      int
+	// ThinkTime and ThinkJitter are in milliseconds
+	ThinkTime   *int
+	ThinkJitter *int
+	GRPC        *grpcConfig
 }
 
-func (a *argsModel) Apply() {
+// Apply overrides the run's flag defaults with any values set on the request
+func (a *argsModel) Apply(run *runConfig) {
 	if a.Redash != nil && !*a.Redash {
-		*redash = false
+		run.Redash = false
 	} else {
-		*redash = true
+		run.Redash = true
 	}
 	if a.Tenant != "" {
-		*tenant = a.Tenant
+		run.Tenant = a.Tenant
 	}
 	if a.AdminEndpoint != "" {
-		*adminEndpoint = a.AdminEndpoint
+		run.AdminEndpoint = a.AdminEndpoint
+	}
+	if a.AdminUser != "" {
+		run.AdminUser = a.AdminUser
 	}
 	if a.AdminPassword != "" {
-		*adminEndpoint = a.AdminPassword
+		run.AdminPassword = a.AdminPassword
 	}
 	if a.Domain != "" {
-		*domain = a.Domain
+		run.Domain = a.Domain
 	}
 	if a.Operation != "" {
-		*operation = a.Operation
+		run.Operation = a.Operation
 	}
 	if a.NumberUsers > 0 {
-		*numberUsers = a.NumberUsers
+		run.NumberUsers = a.NumberUsers
 	}
 	if a.NumberSecrets > 0 {
-		*numberSecrets = a.NumberSecrets
+		run.NumberSecrets = a.NumberSecrets
 	}
 	if a.NumberPermissions > 0 {
-		*numberPermissions = a.NumberPermissions
+		run.NumberPermissions = a.NumberPermissions
 	}
 	if a.SecretLength > 0 {
-		*secretLength = a.SecretLength
+		run.SecretLength = a.SecretLength
 	}
 	if a.CliVersion != "" {
-		*cliVersion = a.CliVersion
+		run.CliVersion = a.CliVersion
+	}
+	if a.Executor != "" {
+		run.Executor = a.Executor
 	}
 	if a.LoadDuration > 0 {
-		*loadDuration = a.LoadDuration
+		run.LoadDuration = a.LoadDuration
 	}
 	if a.LoadRate > 0 {
-		*loadRate = a.LoadRate
+		run.LoadRate = a.LoadRate
+	}
+	if len(a.LoadPhases) > 0 {
+		run.LoadPhases = a.LoadPhases
+	}
+	if !a.Thresholds.empty() {
+		run.Thresholds = a.Thresholds
+	}
+	if a.Scenario != "" {
+		run.Scenario = a.Scenario
+	}
+	if a.Baseline != "" {
+		run.Baseline = a.Baseline
+	}
+	if a.SaveBaseline != "" {
+		run.SaveBaseline = a.SaveBaseline
+	}
+	if a.FailOnRegression != nil {
+		run.FailOnRegression = *a.FailOnRegression
+	}
+	a.Tolerances.apply(&run.Tolerances)
+	if a.RecordResults != nil {
+		run.RecordResults = *a.RecordResults
+	}
+	if len(a.Workload) > 0 {
+		run.Workload = a.Workload
+	}
+	if a.BodySize > 0 {
+		run.BodySize = a.BodySize
+	}
+	if a.Targets != "" {
+		run.Targets = a.Targets
+	}
+	if a.LoopTargets != nil {
+		run.LoopTargets = *a.LoopTargets
+	}
+	if a.RandomTargets != nil {
+		run.RandomTargets = *a.RandomTargets
+	}
+	if len(a.Templates) > 0 {
+		run.Templates = a.Templates
+	}
+	if a.VirtualUsers > 0 {
+		run.VirtualUsers = a.VirtualUsers
+	}
+	if a.ThinkTime != nil {
+		run.ThinkTime = time.Duration(*a.ThinkTime) * time.Millisecond
+	}
+	if a.ThinkJitter != nil {
+		run.ThinkJitter = time.Duration(*a.ThinkJitter) * time.Millisecond
+	}
+	if a.GRPC != nil {
+		run.GRPC = a.GRPC
 	}
 }
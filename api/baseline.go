@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// status of a run whose load test regressed against its baseline, when the
+// run asks to fail on regressions
+const statusRegressed = 3
+
+// epsilon keeps float rounding from turning a change right at a tolerance
+// into a regression
+const epsilon = 1e-9
+
+var (
+	baseline            = flag.String("baseline", "", "Compare the run against the latest run saved as this baseline. Without it, runs of a named scenario are compared to the last run of that scenario that passed")
+	saveBaseline        = flag.String("save-baseline", "", "Save the run as this named baseline")
+	failOnRegression    = flag.Bool("fail-on-regression", false, "Fail the run if it regressed against its baseline")
+	latencyTolerance    = flag.Float64("latency-tolerance", 0.1, "Largest relative increase in mean or a latency percentile that is not a regression")
+	successTolerance    = flag.Float64("success-tolerance", 0.01, "Largest drop in success ratio that is not a regression")
+	throughputTolerance = flag.Float64("throughput-tolerance", 0.1, "Largest relative drop in throughput that is not a regression")
+)
+
+// tolerances are how much worse than its baseline a run may be before it is
+// flagged as a regression
+type tolerances struct {
+	// Latency is a relative increase, 0.1 allows 10% slower
+	Latency float64
+	// Success is an absolute drop in success ratio
+	Success float64
+	// Throughput is a relative drop, 0.1 allows 10% fewer successful rps
+	Throughput float64
+}
+
+// toleranceOverrides are tolerances set on a request or scenario. Unset ones
+// keep the flag defaults so a tolerance of 0 can still be asked for.
+type toleranceOverrides struct {
+	Latency    *float64 `json:"latency"`
+	Success    *float64 `json:"success"`
+	Throughput *float64 `json:"throughput"`
+}
+
+func (o *toleranceOverrides) apply(t *tolerances) {
+	if o == nil {
+		return
+	}
+	if o.Latency != nil {
+		t.Latency = *o.Latency
+	}
+	if o.Success != nil {
+		t.Success = *o.Success
+	}
+	if o.Throughput != nil {
+		t.Throughput = *o.Throughput
+	}
+}
+
+func (o *toleranceOverrides) validate() []string {
+	problems := []string{}
+	if o == nil {
+		return problems
+	}
+	if o.Latency != nil && *o.Latency < 0 {
+		problems = append(problems, "tolerances.latency: must not be negative")
+	}
+	if o.Success != nil && *o.Success < 0 {
+		problems = append(problems, "tolerances.success: must not be negative")
+	}
+	if o.Throughput != nil && *o.Throughput < 0 {
+		problems = append(problems, "tolerances.throughput: must not be negative")
+	}
+	return problems
+}
+
+// delta is the change in one metric between the baseline and the run
+type delta struct {
+	Metric   string  `json:"metric"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+	// Change is relative for latencies and throughput, e.g. 0.25 is 25% up,
+	// and absolute for the success ratio
+	Change     float64 `json:"change"`
+	Regression bool    `json:"regression"`
+}
+
+// comparison is how a run measured up against its baseline
+type comparison struct {
+	BaselineID   string  `json:"baselineId"`
+	BaselineName string  `json:"baselineName,omitempty"`
+	Deltas       []delta `json:"deltas"`
+	Regressed    bool    `json:"regressed"`
+}
+
+// compareMetrics reports the deltas from the baseline to the current metrics
+func compareMetrics(base, current *fleetMetrics, t tolerances) []delta {
+	deltas := []delta{}
+	latencies := []struct {
+		name          string
+		base, current time.Duration
+	}{
+		{"mean", base.Mean, current.Mean},
+		{"p50", base.P50, current.P50},
+		{"p95", base.P95, current.P95},
+		{"p99", base.P99, current.P99},
+		{"max", base.Max, current.Max},
+	}
+	for _, l := range latencies {
+		change := relativeChange(toMillis(l.base), toMillis(l.current))
+		deltas = append(deltas, delta{
+			Metric:     l.name,
+			Baseline:   toMillis(l.base),
+			Current:    toMillis(l.current),
+			Change:     change,
+			Regression: change > t.Latency+epsilon,
+		})
+	}
+	successChange := current.Success - base.Success
+	deltas = append(deltas, delta{
+		Metric:     "success",
+		Baseline:   base.Success,
+		Current:    current.Success,
+		Change:     successChange,
+		Regression: -successChange > t.Success+epsilon,
+	})
+	throughputChange := relativeChange(base.Throughput, current.Throughput)
+	deltas = append(deltas, delta{
+		Metric:     "throughput",
+		Baseline:   base.Throughput,
+		Current:    current.Throughput,
+		Change:     throughputChange,
+		Regression: -throughputChange > t.Throughput+epsilon,
+	})
+	return deltas
+}
+
+func relativeChange(base, current float64) float64 {
+	if base == 0 {
+		if current == 0 {
+			return 0
+		}
+		// nothing to scale by, so any change counts as doubling
+		if current > 0 {
+			return 1
+		}
+		return -1
+	}
+	return (current - base) / base
+}
+
+// compareToBaseline compares the run's metrics to its baseline. It returns
+// nil when the run asked for no comparison or there is nothing to compare to.
+func compareToBaseline(run *runConfig, current *fleetMetrics) (*comparison, error) {
+	base, err := findBaseline(run.Baseline, run.Scenario, run.ID)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		if run.Baseline != "" {
+			return nil, fmt.Errorf("no run saved as baseline '%s'", run.Baseline)
+		}
+		if run.Scenario != "" {
+			fmt.Printf("no passing run of scenario %s to compare against\n", run.Scenario)
+		}
+		return nil, nil
+	}
+	c := &comparison{
+		BaselineID:   base.ID,
+		BaselineName: base.Baseline,
+		Deltas:       compareMetrics(base.Metrics, current, run.Tolerances),
+	}
+	for _, d := range c.Deltas {
+		if d.Regression {
+			c.Regressed = true
+			fmt.Printf("regression in %s against run %s: %.2f -> %.2f\n", d.Metric, base.ID, d.Baseline, d.Current)
+		}
+	}
+	return c, nil
+}
+
+// recordRun stores the run's metrics so later runs can use it as a baseline
+func recordRun(run *runConfig, metrics *fleetMetrics, passed bool) {
+	record := &runRecord{
+		ID:       run.ID,
+		Scenario: run.Scenario,
+		Baseline: run.SaveBaseline,
+		Tenant:   run.Tenant,
+		Passed:   passed,
+		Finished: time.Now(),
+		Metrics:  metrics,
+	}
+	if err := runs.Save(record); err != nil {
+		fmt.Printf("failed to save metrics of run %s: %v\n", run.ID, err)
+		return
+	}
+	pruneRuns(run.Scenario)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRelativeChange(t *testing.T) {
+	tests := []struct {
+		base, current float64
+		want          float64
+	}{
+		{100, 100, 0},
+		{100, 110, 0.1},
+		{100, 90, -0.1},
+		{100, 0, -1},
+		{0, 0, 0},
+		{0, 5, 1},
+		{0, -5, -1},
+	}
+	for _, test := range tests {
+		if got := relativeChange(test.base, test.current); math.Abs(got-test.want) > 1e-12 {
+			t.Errorf("relativeChange(%v, %v) = %v, want %v", test.base, test.current, got, test.want)
+		}
+	}
+}
+
+func TestCompareMetricsAtToleranceEdges(t *testing.T) {
+	base := &fleetMetrics{
+		Mean:       100 * time.Millisecond,
+		P50:        100 * time.Millisecond,
+		P95:        200 * time.Millisecond,
+		P99:        400 * time.Millisecond,
+		Max:        time.Second,
+		Success:    0.99,
+		Throughput: 100,
+	}
+	tolerance := tolerances{Latency: 0.1, Success: 0.01, Throughput: 0.1}
+	tests := []struct {
+		name    string
+		current func(m *fleetMetrics)
+		t       tolerances
+		// the metrics flagged as regressions
+		regressed []string
+	}{
+		{"unchanged", func(m *fleetMetrics) {}, tolerance, []string{}},
+		{"latency at tolerance", func(m *fleetMetrics) { m.P95 = 220 * time.Millisecond }, tolerance, []string{}},
+		{"latency past tolerance", func(m *fleetMetrics) { m.P95 = 221 * time.Millisecond }, tolerance, []string{"p95"}},
+		{"faster", func(m *fleetMetrics) { m.Mean, m.Max = 10*time.Millisecond, 500*time.Millisecond }, tolerance, []string{}},
+		{"every latency past tolerance", func(m *fleetMetrics) {
+			m.Mean, m.P50, m.P95, m.P99, m.Max = 2*m.Mean, 2*m.P50, 2*m.P95, 2*m.P99, 2*m.Max
+		}, tolerance, []string{"mean", "p50", "p95", "p99", "max"}},
+		{"success at tolerance", func(m *fleetMetrics) { m.Success = 0.98 }, tolerance, []string{}},
+		{"success past tolerance", func(m *fleetMetrics) { m.Success = 0.979 }, tolerance, []string{"success"}},
+		{"success up", func(m *fleetMetrics) { m.Success = 1 }, tolerance, []string{}},
+		{"throughput at tolerance", func(m *fleetMetrics) { m.Throughput = 90 }, tolerance, []string{}},
+		{"throughput past tolerance", func(m *fleetMetrics) { m.Throughput = 89.9 }, tolerance, []string{"throughput"}},
+		{"throughput up", func(m *fleetMetrics) { m.Throughput = 200 }, tolerance, []string{}},
+		{"zero tolerance, unchanged", func(m *fleetMetrics) {}, tolerances{}, []string{}},
+		{"zero tolerance flags any change", func(m *fleetMetrics) {
+			m.P50 = 101 * time.Millisecond
+			m.Success = 0.989
+			m.Throughput = 99.9
+		}, tolerances{}, []string{"p50", "success", "throughput"}},
+	}
+	for _, test := range tests {
+		current := *base
+		test.current(&current)
+		regressed := []string{}
+		for _, d := range compareMetrics(base, &current, test.t) {
+			if d.Regression {
+				regressed = append(regressed, d.Metric)
+			}
+		}
+		if fmt.Sprint(regressed) != fmt.Sprint(test.regressed) {
+			t.Errorf("%s: regressed %v, want %v", test.name, regressed, test.regressed)
+		}
+	}
+}
+
+func TestCompareMetricsFromZeroBase(t *testing.T) {
+	// a baseline with no successful requests has nothing to scale by
+	base := &fleetMetrics{}
+	tests := []struct {
+		name      string
+		current   *fleetMetrics
+		regressed []string
+	}{
+		{"still nothing", &fleetMetrics{}, []string{}},
+		{"now responding", &fleetMetrics{Mean: time.Millisecond, Success: 1, Throughput: 10}, []string{"mean"}},
+	}
+	for _, test := range tests {
+		regressed := []string{}
+		for _, d := range compareMetrics(base, test.current, tolerances{Latency: 0.5, Success: 0.01, Throughput: 0.1}) {
+			if d.Regression {
+				regressed = append(regressed, d.Metric)
+			}
+		}
+		if fmt.Sprint(regressed) != fmt.Sprint(test.regressed) {
+			t.Errorf("%s: regressed %v, want %v", test.name, regressed, test.regressed)
+		}
+	}
+}
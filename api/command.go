@@ -1,6 +1,11 @@
 package main
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
 
 type Command interface {
 	GetArgs() []string
@@ -96,15 +101,22 @@ func (c *PermissionCreateCommand) GetArgs() []string {
 		"--path",
 		c.Path,
 		"--action",
-		"<read|delete|create|update>",
+		c.Action(),
 		"--effect",
 		"allow",
 	}
 }
 
+// Action is the set of actions the permission allows
+func (c *PermissionCreateCommand) Action() string {
+	return "<read|delete|create|update>"
+}
+
 type CmdResult struct {
 	Type  string
 	Value string
+	// Token is the full token when Type is token, so it can be refreshed
+	Token *TokenResult
 }
 
 type TokenResult struct {
@@ -121,6 +133,7 @@ func GetTokenResult(output []byte) *CmdResult {
 		return &CmdResult{
 			Type:  "token",
 			Value: tr.AccessToken,
+			Token: &tr,
 		}
 	}
 	return nil
@@ -128,4 +141,18 @@ func GetTokenResult(output []byte) *CmdResult {
 
 type AsyncCommandSet []Command
 
+// describe summarizes the set by command type, e.g. "10 secret, 5 user"
+func (s AsyncCommandSet) describe() string {
+	counts := map[string]int{}
+	for _, c := range s {
+		counts[c.GetType()]++
+	}
+	parts := []string{}
+	for t, n := range counts {
+		parts = append(parts, fmt.Sprintf("%d %s", n, t))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
 type SyncCommandSet []AsyncCommandSet
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	executorType = flag.String("executor", "cli", "How setup commands are run [cli|http]. http calls the tenant api directly rather than forking the cli per object")
+	apiPrefix    = flag.String("api-prefix", "/v1", "Path prefix of the tenant api used by --executor=http")
+)
+
+// Executor runs setup commands against a tenant
+type Executor interface {
+	// Execute runs the command. The result is non nil for commands that
+	// produce something the run needs, e.g. tokens.
+	Execute(c Command) (*CmdResult, error)
+}
+
+func newExecutor(run *runConfig) (Executor, error) {
+	switch run.Executor {
+	case "cli":
+		return &cliExecutor{
+			binaryName: run.binaryName,
+			config:     run.cliConfig,
+		}, nil
+	case "http":
+		return newHTTPExecutor(), nil
+	default:
+		return nil, fmt.Errorf("unknown executor '%s'", run.Executor)
+	}
+}
+
+// cliExecutor shells out to the cli for every command
+type cliExecutor struct {
+	binaryName string
+	config     string
+}
+
+func (e *cliExecutor) Execute(c Command) (*CmdResult, error) {
+	cmdArgs := addConfigArg(c.GetArgs(), e.config)
+	cmd := exec.Command(e.binaryName, cmdArgs...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("err executing cmd: %s %s\nErr: \n%s\nOutput:\n %s", e.binaryName, strings.Join(cmdArgs, " "), err, string(output))
+	}
+	if c.GetType() == "token" {
+		return GetTokenResult(output), nil
+	}
+	return nil, nil
+}
+
+// httpExecutor calls the tenant api directly. The config commands that the
+// cli would write to its config file are kept on the executor instead.
+type httpExecutor struct {
+	sync.Mutex
+	config     map[string]string
+	adminToken string
+	client     *http.Client
+}
+
+func newHTTPExecutor() *httpExecutor {
+	return &httpExecutor{
+		config: map[string]string{},
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: *setupWorkers,
+			},
+		},
+	}
+}
+
+func (e *httpExecutor) Execute(c Command) (*CmdResult, error) {
+	switch cmd := c.(type) {
+	case *BaseCommand:
+		if strings.Join(cmd.Args, " ") == "auth clear" {
+			e.Lock()
+			e.adminToken = ""
+			e.Unlock()
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unsupported command for http executor: %s", strings.Join(cmd.Args, " "))
+	case *ConfigCommand:
+		e.Lock()
+		defer e.Unlock()
+		e.config[cmd.Path] = cmd.Val
+		return nil, nil
+	case *UserCreateCommand:
+		return nil, e.post("/users", map[string]interface{}{
+			"username": cmd.Name,
+			"password": cmd.Pass,
+		})
+	case *SecretCreateCommand:
+		return nil, e.post("/secrets/"+strings.TrimPrefix(cmd.Path, "/"), map[string]interface{}{
+			"data": cmd.Data,
+		})
+	case *PermissionCreateCommand:
+		return nil, e.post("/permissions", map[string]interface{}{
+			"subjects":  []string{cmd.User},
+			"resources": []string{cmd.Path},
+			"actions":   []string{cmd.Action()},
+			"effect":    "allow",
+		})
+	case *TokenCreateCommand:
+		token, err := e.token(cmd.User, cmd.User+"@1")
+		if err != nil {
+			return nil, err
+		}
+		return &CmdResult{Type: "token", Value: token.AccessToken, Token: token}, nil
+	default:
+		return nil, fmt.Errorf("unsupported command type for http executor: %s", c.GetType())
+	}
+}
+
+func (e *httpExecutor) baseURL() string {
+	e.Lock()
+	defer e.Unlock()
+	base := strings.NewReplacer("{tenant}", e.config["tenant"], "{domain}", e.config["domain"]).Replace(*tenantURL)
+	return strings.TrimSuffix(base, "/") + *apiPrefix
+}
+
+// token authenticates as the user with the password grant
+func (e *httpExecutor) token(user, pass string) (*TokenResult, error) {
+	body := map[string]interface{}{
+		"grant_type": "password",
+		"username":   user,
+		"password":   pass,
+	}
+	data, err := e.send("POST", "/token", body, "")
+	if err != nil {
+		return nil, err
+	}
+	var tr TokenResult
+	if err := json.Unmarshal(data, &tr); err != nil {
+		return nil, err
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("no access token returned authenticating " + user)
+	}
+	return &tr, nil
+}
+
+// authToken returns the cached admin token, authenticating if needed
+func (e *httpExecutor) authToken() (string, error) {
+	e.Lock()
+	token, user, pass := e.adminToken, e.config["auth.username"], e.config["auth.password"]
+	e.Unlock()
+	if token != "" {
+		return token, nil
+	}
+	tr, err := e.token(user, pass)
+	if err != nil {
+		return "", err
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.adminToken = tr.AccessToken
+	return e.adminToken, nil
+}
+
+func (e *httpExecutor) post(path string, body interface{}) error {
+	token, err := e.authToken()
+	if err != nil {
+		return err
+	}
+	_, err = e.send("POST", path, body, token)
+	return err
+}
+
+func (e *httpExecutor) send(method, path string, body interface{}, token string) ([]byte, error) {
+	asBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := e.baseURL() + path
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(asBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("err executing %s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("err executing %s %s: status %d\nOutput:\n %s", method, url, resp.StatusCode, string(data))
+	}
+	return data, nil
+}
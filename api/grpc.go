@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"text/template"
+)
+
+// grpcConfig has loadbots call a unary gRPC method instead of the tenant's
+// http api. Request is a template of the JSON request message, and it and
+// the values of Metadata can use the same variables and generators as target
+// templates. Without Protos, the sources of the proto files describing the
+// method by file name, loadbots ask the server's reflection service.
+// Insecure skips verifying the server's certificate, and MaxWorkers caps each
+// loadbot's calls in flight.
+type grpcConfig struct {
+	Target      string            `json:"target"`
+	Method      string            `json:"method"`
+	Request     string            `json:"request"`
+	Metadata    map[string]string `json:"metadata"`
+	Protos      map[string]string `json:"protos"`
+	Plaintext   bool              `json:"plaintext"`
+	Insecure    bool              `json:"insecure"`
+	Connections int               `json:"connections"`
+	MaxWorkers  int               `json:"maxWorkers"`
+}
+
+// validate returns a description of each problem with the config. The
+// method itself is only resolved by the loadbots.
+func (c *grpcConfig) validate() []string {
+	problems := []string{}
+	if c == nil {
+		return problems
+	}
+	if c.Target == "" {
+		problems = append(problems, "target is required")
+	}
+	if c.Method == "" {
+		problems = append(problems, "method is required")
+	}
+	if c.Connections < 0 {
+		problems = append(problems, "connections must not be negative")
+	}
+	if c.MaxWorkers < 0 {
+		problems = append(problems, "maxWorkers must not be negative")
+	}
+	fields := map[string]string{"request": c.Request}
+	for key, value := range c.Metadata {
+		fields["metadata."+key] = value
+	}
+	for name, text := range fields {
+		if _, err := template.New(name).Funcs(templateFuncs).Parse(text); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
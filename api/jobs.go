@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	jobQueued    = "queued"
+	jobSetup     = "setup"
+	jobTesting   = "testing"
+	jobTeardown  = "teardown"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// how long a cancel request waits for the load test to wind down
+const cancelWait = 30 * time.Second
+
+var (
+	maxJobs      = flag.Int("max-jobs", 4, "Max number of operations to run at once in serve mode. The rest are queued")
+	jobRetention = flag.Duration("job-retention", 24*time.Hour, "How long finished jobs are kept for polling in serve mode")
+
+	jobs     = newJobStore()
+	jobSlots chan struct{}
+)
+
+// job tracks an operation started through /command so clients can poll for
+// its progress rather than holding a connection open for the whole run
+type job struct {
+	lock       sync.Mutex
+	cancel     context.CancelFunc
+	done       chan struct{}
+	selector   string
+	ID         string
+	Tenant     string
+	Operation  string
+	State      string
+	Stage      string
+	Status     int
+	Violations []violation     `json:",omitempty"`
+	Comparison *comparison     `json:",omitempty"`
+	Result     json.RawMessage `json:",omitempty"`
+	Created    time.Time
+	Updated    time.Time
+}
+
+func (j *job) setState(state string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.State = state
+	j.Stage = ""
+	j.Updated = time.Now()
+}
+
+func (j *job) setStage(stage string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.Stage = stage
+	j.Updated = time.Now()
+}
+
+// setResult records the payload of the run so far, e.g. once the load test
+// finishes but teardown is still to come
+func (j *job) setResult(resp []byte) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.setResultLocked(resp)
+	j.Updated = time.Now()
+}
+
+func (j *job) setResultLocked(resp []byte) {
+	if len(resp) == 0 {
+		return
+	}
+	if json.Valid(resp) {
+		j.Result = resp
+	} else {
+		j.Result, _ = json.Marshal(&respWrapper{Error: string(resp)})
+	}
+}
+
+// setViolations records the thresholds the load test broke
+func (j *job) setViolations(violations []violation) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.Violations = violations
+	j.Updated = time.Now()
+}
+
+// setComparison records how the load test measured up against its baseline
+func (j *job) setComparison(c *comparison) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.Comparison = c
+	j.Updated = time.Now()
+}
+
+func (j *job) finish(status int, resp []byte, cancelled bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.Status = status
+	if cancelled {
+		j.State = jobCancelled
+	} else if status == 0 {
+		j.State = jobDone
+	} else {
+		j.State = jobFailed
+	}
+	j.setResultLocked(resp)
+	j.Updated = time.Now()
+	jobsFinished.WithLabelValues(j.Operation, j.State).Inc()
+	close(j.done)
+}
+
+// httpStatus maps the outcome of a finished job to the status /command
+// answers with when asked to wait
+func (j *job) httpStatus() int {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	switch {
+	case j.Status == 0:
+		return http.StatusOK
+	case j.Status == statusThresholdsFailed, j.Status == statusRegressed:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (j *job) finished() bool {
+	return j.State == jobDone || j.State == jobFailed || j.State == jobCancelled
+}
+
+// running reports whether the job has yet to produce its load test result
+func (j *job) running() bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.State == jobQueued || j.State == jobSetup || j.State == jobTesting
+}
+
+// MarshalJSON snapshots the job under its lock
+func (j *job) MarshalJSON() ([]byte, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	type plain job
+	return json.Marshal((*plain)(j))
+}
+
+type jobStore struct {
+	sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{
+		jobs: map[string]*job{},
+	}
+}
+
+func (s *jobStore) create(run *runConfig) *job {
+	s.Lock()
+	defer s.Unlock()
+	s.prune()
+	now := time.Now()
+	j := &job{
+		ID:        run.ID,
+		cancel:    run.cancel,
+		done:      make(chan struct{}),
+		selector:  run.Selector,
+		Tenant:    run.Tenant,
+		Operation: run.Operation,
+		State:     jobQueued,
+		Created:   now,
+		Updated:   now,
+	}
+	s.jobs[j.ID] = j
+	return j
+}
+
+func (s *jobStore) get(id string) *job {
+	s.Lock()
+	defer s.Unlock()
+	return s.jobs[id]
+}
+
+func (s *jobStore) list() []*job {
+	s.Lock()
+	defer s.Unlock()
+	all := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		all = append(all, j)
+	}
+	sort.Slice(all, func(a, b int) bool { return all[a].Created.Before(all[b].Created) })
+	return all
+}
+
+// prune drops finished jobs older than the retention period. Caller holds the lock.
+func (s *jobStore) prune() {
+	cutoff := time.Now().Add(-*jobRetention)
+	for id, j := range s.jobs {
+		j.lock.Lock()
+		expired := j.finished() && j.Updated.Before(cutoff)
+		j.lock.Unlock()
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// startJob queues the run and returns immediately. At most --max-jobs runs
+// execute at once.
+func startJob(run *runConfig) *job {
+	j := jobs.create(run)
+	run.job = j
+	go func() {
+		select {
+		case jobSlots <- struct{}{}:
+			defer func() { <-jobSlots }()
+		case <-run.ctx.Done():
+			j.finish(1, nil, true)
+			return
+		}
+
+		log.Printf("starting job %s: %s for tenant %s\n", j.ID, run.Operation, run.Tenant)
+		if err := run.ensureCli(); err != nil {
+			j.finish(1, []byte(err.Error()), run.cancelled())
+			return
+		}
+		status, resp := runTasks(run)
+		j.finish(status, resp, run.cancelled())
+		log.Printf("finished job %s with status %d\n", j.ID, status)
+	}()
+	return j
+}
+
+// serveJobs handles GET /jobs, GET /jobs/{id}, GET /jobs/{id}/live,
+// GET /jobs/{id}/results and POST /jobs/{id}/cancel
+func serveJobs(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"), "/")
+	id := parts[0]
+	if len(parts) == 2 && parts[1] == "cancel" && r.Method == "POST" {
+		cancelJob(w, id)
+		return
+	}
+	if len(parts) == 2 && (parts[1] == "live" || parts[1] == "results") && r.Method == "GET" {
+		j := jobs.get(id)
+		if j == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Error: no job with id " + id))
+		} else if parts[1] == "live" {
+			serveJobLive(w, j)
+		} else {
+			serveJobResults(w, r, j)
+		}
+		return
+	}
+	if len(parts) > 1 || r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if id == "" {
+		writeJSON(w, http.StatusOK, jobs.list())
+		return
+	}
+	j := jobs.get(id)
+	if j == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Error: no job with id " + id))
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// cancelJob stops the job's run and its loadbots, then waits a little for the
+// partial load test result so it can be returned straight away
+func cancelJob(w http.ResponseWriter, id string) {
+	j := jobs.get(id)
+	if j == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Error: no job with id " + id))
+		return
+	}
+	log.Printf("cancelling job %s\n", id)
+	j.cancel()
+
+	deadline := time.Now().Add(cancelWait)
+	for j.running() && time.Now().Before(deadline) {
+		time.Sleep(250 * time.Millisecond)
+	}
+	status := http.StatusOK
+	if j.running() {
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, j)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	asBytes, err := json.Marshal(body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(asBytes)
+}
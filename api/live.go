@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const liveTimeout = 5 * time.Second
+
+// loaderLive is what a loadbot's /live reports while it attacks
+type loaderLive struct {
+	RunID      string
+	Began      time.Time
+	Elapsed    time.Duration
+	Done       bool
+	Last1s     *histogramMetrics
+	Last10s    *histogramMetrics
+	Cumulative *histogramMetrics
+}
+
+// fleetLive is the progress of a run's load test across every loadbot
+type fleetLive struct {
+	RunID    string
+	Loadbots int
+	Elapsed  time.Duration
+	Done     bool
+	// rolling windows over the last complete seconds, and the whole attack so far
+	Last1s     *fleetMetrics
+	Last10s    *fleetMetrics
+	Cumulative *fleetMetrics
+}
+
+// liveProgress polls every loadbot for its rolling metrics of the run
+func liveProgress(runID, selector string) (*fleetLive, error) {
+	clientset, loadbots, err := listLoadbots(selector)
+	if err != nil {
+		return nil, err
+	}
+	parts := []loaderLive{}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	wg.Add(len(loadbots))
+	endpoint := "live?run=" + url.QueryEscape(runID)
+	for ix := range loadbots {
+		go func(ix int) {
+			defer wg.Done()
+			data, err := getFromLoadbot(clientset, loadbots[ix], endpoint, liveTimeout)
+			if err != nil {
+				// loadbots that never joined the run answer 404
+				return
+			}
+			var part loaderLive
+			if err := json.Unmarshal(data, &part); err != nil {
+				fmt.Printf("Error decoding: %v\n", err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			parts = append(parts, part)
+		}(ix)
+	}
+	wg.Wait()
+
+	progress := &fleetLive{
+		RunID:    runID,
+		Loadbots: len(parts),
+		Done:     len(parts) > 0,
+	}
+	var last1s, last10s, cumulative []histogramMetrics
+	for _, p := range parts {
+		if p.Last1s == nil || p.Last10s == nil || p.Cumulative == nil {
+			continue
+		}
+		if p.Elapsed > progress.Elapsed {
+			progress.Elapsed = p.Elapsed
+		}
+		progress.Done = progress.Done && p.Done
+		last1s = append(last1s, *p.Last1s)
+		last10s = append(last10s, *p.Last10s)
+		cumulative = append(cumulative, *p.Cumulative)
+	}
+	progress.Last1s = aggregateMetrics(last1s)
+	progress.Last10s = aggregateMetrics(last10s)
+	progress.Cumulative = aggregateMetrics(cumulative)
+	return progress, nil
+}
+
+// serveJobLive handles GET /jobs/{id}/live with the rolling metrics of the
+// job's load test while it runs
+func serveJobLive(w http.ResponseWriter, j *job) {
+	progress, err := liveProgress(j.ID, j.selector)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+	writeJSON(w, http.StatusOK, progress)
+}
@@ -12,20 +12,23 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 
 	flag "github.com/spf13/pflag"
+
 	vegeta "github.com/tsenart/vegeta/lib"
 )
 
 const (
-	cmdEndpointName = "command"
+	cmdEndpointName    = "command"
+	cancelEndpointName = "cancel"
+
+	cancelTimeout = 10 * time.Second
 )
 
 var (
@@ -38,13 +41,18 @@ var (
 )
 
 type respWrapper struct {
-	Data  interface{}
-	Error string
+	Data       interface{}
+	Error      string
+	Cancelled  bool
+	Violations []violation `json:",omitempty"`
+	Comparison *comparison `json:",omitempty"`
+	// Routes are the whole fleet's metrics for each route
+	Routes map[string]*fleetMetrics `json:",omitempty"`
 }
 
-func taskLoadtest(model *postLoaderModel) (status int, resp []byte) {
+func taskLoadtest(run *runConfig, model *postLoaderModel) (status int, resp []byte) {
 	log.Println("---Starting test task")
-	results, err := runTest(model)
+	results, err := runTest(run, model)
 	var wrapper respWrapper
 	if err != nil {
 		fmt.Println("error running load test: " + err.Error())
@@ -54,51 +62,83 @@ func taskLoadtest(model *postLoaderModel) (status int, resp []byte) {
 		status = 1
 	} else {
 		wrapper = respWrapper{
-			Data: results,
+			Data:   results,
+			Routes: fleetRoutes(results),
 		}
 	}
-	if !*redash {
+	if run.cancelled() {
+		fmt.Println("load test was cancelled. results are partial")
+		wrapper.Cancelled = true
+		status = 1
+	} else if err == nil {
+		status = checkResults(run, results, &wrapper)
+	}
+	if !run.Redash {
 		resp, _ = json.Marshal(&wrapper)
 	} else {
 		redashData := vegetaResultsToRedash(results)
+		redashData.Violations = wrapper.Violations
+		redashData.Comparison = wrapper.Comparison
 		resp, _ = json.Marshal(redashData)
 	}
 	log.Println("---Finished test task")
 	return status, resp
 }
 
-func runTest(model *postLoaderModel) ([]vegeta.Metrics, error) {
-	// TODO : figure out why DNS resolution of pods isnt working
-	*useIP = true
-	var errAny error
+// checkResults holds the results up against the run's thresholds and baseline,
+// then records them so later runs can be compared against this one
+func checkResults(run *runConfig, results []loaderMetrics, wrapper *respWrapper) (status int) {
+	fleet := aggregateMetrics(overallMetrics(results))
+	if !run.Thresholds.empty() {
+		wrapper.Violations = run.Thresholds.evaluate(fleet)
+		for _, v := range wrapper.Violations {
+			fmt.Printf("threshold %s violated. limit: %s, actual: %s\n", v.Threshold, v.Limit, v.Actual)
+		}
+		if len(wrapper.Violations) > 0 {
+			status = statusThresholdsFailed
+		}
+		if run.job != nil {
+			run.job.setViolations(wrapper.Violations)
+		}
+	}
 
-	config, err := rest.InClusterConfig()
+	comparison, err := compareToBaseline(run, fleet)
 	if err != nil {
-		fmt.Printf("Error creating config: %v", err)
-		return nil, err
+		fmt.Printf("failed to compare against baseline: %v\n", err)
 	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		fmt.Printf("Error client: %v", err)
-		return nil, err
+	regressed := comparison != nil && comparison.Regressed
+	if comparison != nil {
+		wrapper.Comparison = comparison
+		if run.job != nil {
+			run.job.setComparison(comparison)
+		}
+		if regressed && run.FailOnRegression && status == 0 {
+			status = statusRegressed
+		}
 	}
-	pods, err := clientset.CoreV1().Pods("").List(metav1.ListOptions{
-		LabelSelector: *selector,
-	})
+
+	// a regressed run is kept but never becomes a scenario's baseline
+	recordRun(run, fleet, len(wrapper.Violations) == 0 && !regressed)
+	return status
+}
+
+func runTest(run *runConfig, model *postLoaderModel) ([]loaderMetrics, error) {
+	var errAny error
+
+	clientset, loadbots, err := listLoadbots(run.Selector)
 	if err != nil {
-		fmt.Printf("Error getting pods: %v", err)
 		return nil, err
 	}
-	loadbots := []*corev1.Pod{}
-	for ix := range pods.Items {
-		pod := &pods.Items[ix]
-		if pod.Status.PodIP == "" {
-			continue
-		}
-		loadbots = append(loadbots, pod)
+	if len(loadbots) == 0 {
+		// a run that sends nothing must not pass, or become a baseline
+		return nil, fmt.Errorf("no ready loadbots match selector '%s'", run.Selector)
+	}
+	if run.VirtualUsers > 0 && run.VirtualUsers < len(loadbots) {
+		// a loadbot without users would fall back to an open model attack
+		loadbots = loadbots[:run.VirtualUsers]
 	}
 	numberLoadBots := len(loadbots)
-	parts := []vegeta.Metrics{}
+	parts := []loaderMetrics{}
 	lock := sync.Mutex{}
 	wg := sync.WaitGroup{}
 	wg.Add(numberLoadBots)
@@ -107,63 +147,158 @@ func runTest(model *postLoaderModel) ([]vegeta.Metrics, error) {
 	fmt.Printf("Found %d loadbots for load test\n", len(loadbots))
 	ratePer := int(float64(model.Rate) / math.Max(1.0, float64(numberLoadBots)))
 	fmt.Printf("Spreading total rate %d rps to %d rps per bot\n", model.Rate, ratePer)
-	model.Rate = ratePer
+	botModel := *model
+	botModel.Rate = ratePer
+	botModel.RunID = run.ID
+	botModel.RecordResults = run.RecordResults
+	botModel.Workload = run.Workload
+	botModel.BodySize = run.BodySize
+	if botModel.BodySize == 0 {
+		botModel.BodySize = run.SecretLength
+	}
+	botModel.Templates = run.Templates
+	botModel.GRPC = run.GRPC
+	if len(model.Phases) > 0 {
+		botModel.Phases = splitPhases(model.Phases, numberLoadBots)
+		botModel.Duration = phasesDuration(model.Phases)
+		fmt.Printf("Spreading %d phase load profile across %d bots\n", len(model.Phases), numberLoadBots)
+	}
 
-	bodyMarshalled, err := json.Marshal(model)
-	clientTimeout := time.Duration(*loadDuration*6/5) * time.Second
-	if err != nil {
-		return parts, err
+	var usersPer []int
+	if run.VirtualUsers > 0 {
+		usersPer = splitUsers(run.VirtualUsers, numberLoadBots)
+		botModel.ThinkTime = int(run.ThinkTime / time.Millisecond)
+		botModel.ThinkJitter = int(run.ThinkJitter / time.Millisecond)
+		fmt.Printf("Spreading %d virtual users across %d bots\n", run.VirtualUsers, numberLoadBots)
+	}
+	var shards [][]vegeta.Target
+	if run.Targets != "" {
+		targets, err := loadTargets(run.Targets)
+		if err != nil {
+			return parts, err
+		}
+		botModel.LoopTargets = run.LoopTargets
+		botModel.RandomTargets = run.RandomTargets
+		fmt.Printf("Sharding %d targets across %d bots\n", len(targets), numberLoadBots)
+		shards = shardTargets(targets, numberLoadBots)
+	}
+
+	clientTimeout := time.Duration(botModel.Duration*6/5) * time.Second
+	bodies := make([][]byte, numberLoadBots)
+	for ix := range bodies {
+		if usersPer != nil {
+			botModel.Users = usersPer[ix]
+		}
+		if shards != nil {
+			botModel.Targets = shards[ix]
+		}
+		if bodies[ix], err = json.Marshal(&botModel); err != nil {
+			return parts, err
+		}
 	}
+
+	// on cancel, tell every loadbot to stop. each loadbot then answers its
+	// pending command with the metrics it has so far
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-run.ctx.Done():
+			cancelLoadbots(clientset, loadbots, run.ID)
+		case <-done:
+		}
+	}()
+
 	for ix := range loadbots {
 		go func(ix int) {
 			defer wg.Done()
 			pod := loadbots[ix]
-			var data []byte
 			log.Printf("Sending job to loadbot %s\n", pod.Name)
-			if *useIP {
-				url := "http://" + pod.Status.PodIP + ":8080/command"
-
-				req, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyMarshalled))
-				req.Header.Set("Content-Type", "application/json")
-
-				client := &http.Client{}
-				client.Timeout = clientTimeout
-				resp, err := client.Do(req)
-				if err != nil {
-					fmt.Printf("Error posting task to loader: %v\n", err)
-					if errAny == nil {
-						errAny = err
-					}
-					return
-				}
-				defer resp.Body.Close()
-				if data, err = ioutil.ReadAll(resp.Body); err != nil {
-					fmt.Printf("Error reading load task result: %v\n", err)
-					if errAny == nil {
-						errAny = err
-					}
-					return
-				}
-			} else {
-				var err error
-				podPath := fmt.Sprintf("/api/v1/namespaces/default/pods/%s:8080/proxy/%s", pod.Name, cmdEndpointName)
-				// NOT WORKING - not sure why doesnt resolve
-				data, err = clientset.RESTClient().Post().AbsPath(podPath).Timeout(clientTimeout).Body(bodyMarshalled).DoRaw()
-				if err != nil {
-					fmt.Printf("Error proxying to pod %v: %v\n", podPath, err)
-					return
-				}
-			}
-			var metrics vegeta.Metrics
-			if err := json.Unmarshal(data, &metrics); err != nil {
+			var metrics loaderMetrics
+			data, err := postToLoadbot(clientset, pod, cmdEndpointName, bodies[ix], clientTimeout)
+			if err != nil {
+				fmt.Printf("Error posting task to loader: %v\n", err)
+			} else if err = json.Unmarshal(data, &metrics); err != nil {
 				fmt.Printf("Error decoding: %v\n", err)
-				return
 			}
 			lock.Lock()
 			defer lock.Unlock()
+			if err != nil {
+				if errAny == nil {
+					errAny = fmt.Errorf("loadbot %s: %v", pod.Name, err)
+				}
+				return
+			}
 			parts = append(parts, metrics)
 		}(ix)
 	}
 	wg.Wait()
-	return parts, err
+	if len(parts) == 0 {
+		// e.g. every loadbot rejected the model
+		return parts, fmt.Errorf("every loadbot failed, first with %v", errAny)
+	}
+	return parts, nil
+}
+
+// cancelLoadbots asks every loadbot to stop the attack for the run
+func cancelLoadbots(clientset kubernetes.Interface, loadbots []*corev1.Pod, runID string) {
+	fmt.Printf("Cancelling run %s on %d loadbots\n", runID, len(loadbots))
+	wg := sync.WaitGroup{}
+	wg.Add(len(loadbots))
+	for ix := range loadbots {
+		go func(pod *corev1.Pod) {
+			defer wg.Done()
+			endpoint := fmt.Sprintf("%s?run=%s", cancelEndpointName, url.QueryEscape(runID))
+			if _, err := postToLoadbot(clientset, pod, endpoint, nil, cancelTimeout); err != nil {
+				fmt.Printf("Error cancelling run on loadbot %s: %v\n", pod.Name, err)
+			}
+		}(loadbots[ix])
+	}
+	wg.Wait()
+}
+
+// postToLoadbot posts the body to an endpoint on the loadbot pod and returns the response
+func postToLoadbot(clientset kubernetes.Interface, pod *corev1.Pod, endpoint string, body []byte, timeout time.Duration) ([]byte, error) {
+	return callLoadbot(clientset, pod, "POST", endpoint, body, timeout)
+}
+
+// getFromLoadbot fetches an endpoint on the loadbot pod
+func getFromLoadbot(clientset kubernetes.Interface, pod *corev1.Pod, endpoint string, timeout time.Duration) ([]byte, error) {
+	return callLoadbot(clientset, pod, "GET", endpoint, nil, timeout)
+}
+
+func callLoadbot(clientset kubernetes.Interface, pod *corev1.Pod, method, endpoint string, body []byte, timeout time.Duration) ([]byte, error) {
+	if !*useIP {
+		podPath := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s:8080/proxy/%s", pod.Namespace, pod.Name, endpoint)
+		// NOT WORKING - not sure why doesnt resolve
+		data, err := clientset.CoreV1().RESTClient().Verb(method).AbsPath(podPath).Timeout(timeout).Body(body).DoRaw()
+		if err != nil {
+			fmt.Printf("Error proxying to pod %v: %v\n", podPath, err)
+		}
+		return data, err
+	}
+
+	loadbotURL := "http://" + pod.Status.PodIP + ":8080/" + endpoint
+	req, err := http.NewRequest(method, loadbotURL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	client.Timeout = timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("Error reading loadbot response: %v\n", err)
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("loadbot %s returned %d: %s", pod.Name, resp.StatusCode, string(data))
+	}
+	return data, nil
 }
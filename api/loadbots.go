@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/noahhai/kube-vegeta/loadbots"
+)
+
+const (
+	// how long to wait for a new registry's first list of pods
+	registrySyncTimeout = 30 * time.Second
+	// registries not used for this long stop watching
+	registryIdleTimeout = 10 * time.Minute
+	// at most this many selectors are watched at once
+	maxRegistries = 8
+)
+
+// watchedRegistry is a started registry and what's needed to stop it
+type watchedRegistry struct {
+	*loadbots.Registry
+	stop     chan struct{}
+	lastUsed time.Time
+}
+
+// registries are started the first time a run uses their selector and
+// watch until they go unused, or make room for a newer selector
+var (
+	registryLock   sync.Mutex
+	registryClient kubernetes.Interface
+	registries     = map[string]*watchedRegistry{}
+)
+
+// validSelector checks a loadbot selector before anything watches it. An
+// empty selector would match every pod.
+func validSelector(selector string) error {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return fmt.Errorf("invalid loadbot selector '%s': %v", selector, err)
+	}
+	if parsed.Empty() {
+		return fmt.Errorf("loadbot selector must not be empty")
+	}
+	return nil
+}
+
+func registryFor(selector string) (*loadbots.Registry, error) {
+	if err := validSelector(selector); err != nil {
+		return nil, err
+	}
+	registryLock.Lock()
+	now := time.Now()
+	for watched, w := range registries {
+		if now.Sub(w.lastUsed) > registryIdleTimeout {
+			stopRegistry(watched)
+		}
+	}
+	w, ok := registries[selector]
+	if !ok {
+		if registryClient == nil {
+			config, err := rest.InClusterConfig()
+			if err != nil {
+				registryLock.Unlock()
+				fmt.Printf("Error creating config: %v", err)
+				return nil, err
+			}
+			clientset, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				registryLock.Unlock()
+				fmt.Printf("Error client: %v", err)
+				return nil, err
+			}
+			registryClient = clientset
+		}
+		if len(registries) >= maxRegistries {
+			stopRegistry(leastRecentlyUsedRegistry())
+		}
+		w = &watchedRegistry{Registry: loadbots.NewRegistry(registryClient, selector), stop: make(chan struct{})}
+		w.Start(w.stop)
+		registries[selector] = w
+	}
+	w.lastUsed = now
+	registryLock.Unlock()
+	if err := w.WaitForSync(registrySyncTimeout); err != nil {
+		// rather than leave the informer retrying a list that may never work
+		registryLock.Lock()
+		if registries[selector] == w {
+			stopRegistry(selector)
+		}
+		registryLock.Unlock()
+		return nil, err
+	}
+	return w.Registry, nil
+}
+
+// stopRegistry stops watching the selector. Callers hold registryLock.
+func stopRegistry(selector string) {
+	close(registries[selector].stop)
+	delete(registries, selector)
+}
+
+// leastRecentlyUsedRegistry returns the selector that was used longest ago.
+// Callers hold registryLock.
+func leastRecentlyUsedRegistry() string {
+	var oldest string
+	for selector, w := range registries {
+		if oldest == "" || w.lastUsed.Before(registries[oldest].lastUsed) {
+			oldest = selector
+		}
+	}
+	return oldest
+}
+
+// listLoadbots returns the ready loadbot pods matching the selector
+func listLoadbots(selector string) (kubernetes.Interface, []*corev1.Pod, error) {
+	r, err := registryFor(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	ready, err := r.Loadbots()
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.Clientset(), ready, nil
+}
@@ -1,12 +1,49 @@
 package main
 
+import vegeta "github.com/tsenart/vegeta/lib"
+
 type postLoaderModel struct {
-	Tenant         string
-	Domain         string
-	Rate           int
-	Duration       int
-	SecretPaths    []string
-	Tokens         []string
+	RunID       string
+	Tenant      string
+	Domain      string
+	Rate        int
+	Duration    int
+	Phases      []loadPhase
+	SecretPaths []string
+	Tokens      []string
+	// Credentials carry the refresh token and expiry of each of Tokens so
+	// loadbots can keep them fresh through long tests
+	Credentials    []tokenCredential
+	TokenURL       string
 	StaticTargeter bool
 	Workers        int
+	RecordResults  bool
+	Workload       map[string]int
+	BodySize       int
+	// Targets are replayed instead of reading secrets, sharded per loadbot
+	Targets       []vegeta.Target `json:",omitempty"`
+	LoopTargets   bool
+	RandomTargets bool
+	// Templates generate requests instead of reading secrets
+	Templates []targetTemplate `json:",omitempty"`
+	// Users runs a closed model attack instead of following Rate or Phases.
+	// Think times are in milliseconds.
+	Users       int
+	ThinkTime   int
+	ThinkJitter int
+	// GRPC calls a gRPC method instead of the tenant's http api
+	GRPC *grpcConfig `json:",omitempty"`
+}
+
+// loaderMetrics is what a loadbot returns for a run: its metrics for the whole
+// attack plus a breakdown for each phase of the load profile and each route
+type loaderMetrics struct {
+	histogramMetrics
+	Phases []phaseMetrics `json:"phases,omitempty"`
+	Routes []routeMetrics `json:"routes,omitempty"`
+}
+
+type phaseMetrics struct {
+	Name string `json:"name"`
+	histogramMetrics
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// loadPhase is one stage of a load profile. Rates are in requests per second
+// across the whole fleet until runTest splits them between loadbots.
+//
+//	steady: hold Rate for Duration
+//	spike:  burst to Rate for Duration, then drop back to the rate the
+//	        previous phase ended at for Recovery (Duration if unset)
+//	ramp:   change linearly from From to To over Duration
+//	step:   climb from From to To in Steps equal steps over Duration
+type loadPhase struct {
+	Name     string
+	Type     string
+	Duration int
+	Rate     float64
+	From     float64
+	To       float64
+	Steps    int
+	Recovery int
+}
+
+// validate returns a description of each problem with the phase
+func (p *loadPhase) validate() []string {
+	problems := []string{}
+	if p.Duration <= 0 {
+		problems = append(problems, "duration must be positive")
+	}
+	switch p.Type {
+	case "steady", "spike":
+		if p.Rate < 0 {
+			problems = append(problems, "rate must not be negative")
+		}
+		if p.Recovery < 0 {
+			problems = append(problems, "recovery must not be negative")
+		}
+	case "ramp", "step":
+		if p.From < 0 || p.To < 0 {
+			problems = append(problems, "from and to must not be negative")
+		}
+		if p.Type == "step" && p.Steps < 2 {
+			problems = append(problems, "steps must be at least 2")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("type must be one of steady, spike, ramp or step, got '%s'", p.Type))
+	}
+	return problems
+}
+
+// length is how long the phase runs in seconds, including a spike's recovery
+func (p *loadPhase) length() int {
+	if p.Type != "spike" {
+		return p.Duration
+	}
+	if p.Recovery > 0 {
+		return p.Duration + p.Recovery
+	}
+	return 2 * p.Duration
+}
+
+// label is how the phase is named in results
+func (p *loadPhase) label(ix int) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return fmt.Sprintf("%d-%s", ix, p.Type)
+}
+
+func validatePhases(phases []loadPhase) error {
+	problems := []string{}
+	for ix := range phases {
+		for _, problem := range phases[ix].validate() {
+			problems = append(problems, fmt.Sprintf("phase %d: %s", ix, problem))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid load phases: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// phasesDuration is the total length of the profile in seconds
+func phasesDuration(phases []loadPhase) int {
+	total := 0
+	for ix := range phases {
+		total += phases[ix].length()
+	}
+	return total
+}
+
+// splitPhases divides every rate in the profile between the loadbots
+func splitPhases(phases []loadPhase, numberLoadBots int) []loadPhase {
+	if numberLoadBots < 1 {
+		numberLoadBots = 1
+	}
+	n := float64(numberLoadBots)
+	split := make([]loadPhase, len(phases))
+	for ix, p := range phases {
+		p.Rate /= n
+		p.From /= n
+		p.To /= n
+		p.Name = p.label(ix)
+		split[ix] = p
+	}
+	return split
+}
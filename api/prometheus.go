@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	jobsDesc = prometheus.NewDesc(prometheus.BuildFQName("loadtest", "api", "jobs"), "Jobs kept by the api by state.", []string{"state"}, nil)
+
+	jobsFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "loadtest",
+		Subsystem: "api",
+		Name:      "jobs_finished_total",
+		Help:      "Jobs finished by operation and final state.",
+	}, []string{"operation", "state"})
+	setupCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "loadtest",
+		Subsystem: "api",
+		Name:      "setup_command_duration_seconds",
+		Help:      "Latency of the commands that set up tenants by command type and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"type", "outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(jobCollector{}, jobsFinished, setupCommandDuration)
+}
+
+// jobCollector counts the jobs in the store by state when scraped, so the
+// counts always agree with /jobs
+type jobCollector struct{}
+
+func (jobCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobsDesc
+}
+
+func (jobCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[string]int{}
+	for _, state := range []string{jobQueued, jobSetup, jobTesting, jobTeardown, jobDone, jobFailed, jobCancelled} {
+		counts[state] = 0
+	}
+	for _, j := range jobs.list() {
+		j.lock.Lock()
+		counts[j.State]++
+		j.lock.Unlock()
+	}
+	for state, n := range counts {
+		ch <- prometheus.MustNewConstMetric(jobsDesc, prometheus.GaugeValue, float64(n), state)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	flag "github.com/spf13/pflag"
+)
+
+// adminAuthEnv is where --admin-auth defaults from. api-rc.yaml fills it
+// from the auth key of the loadtest-admin secret.
+const (
+	adminAuthEnv    = "ADMIN_AUTH"
+	adminAuthSecret = "loadtest-admin"
+)
+
+var (
+	provisionerType = flag.String("provisioner", "http", "How tenants are provisioned [http|memory]. memory keeps tenants in process for offline runs")
+	adminAuth       = flag.String("admin-auth", os.Getenv(adminAuthEnv), "Authorization header value for the admin endpoint. Required by --provisioner=http. Defaults to $"+adminAuthEnv)
+	tenantURL       = flag.String("tenant-url", "https://{tenant}.{domain}", "Base url of a tenant. {tenant} and {domain} are substituted")
+
+	memoryTenants = newMemoryProvisioner()
+)
+
+// TenantProvisioner creates and removes the tenants that a run loads
+type TenantProvisioner interface {
+	// Create provisions a new tenant owned by the admin user
+	Create(tenant, adminUser string) error
+	// InitializeAdmin sets the initial admin's password on a created tenant
+	InitializeAdmin(tenant, domain, adminUser, adminPassword string) error
+	// Delete removes the tenant
+	Delete(tenant string) error
+	// Exists reports whether the tenant is provisioned
+	Exists(tenant string) (bool, error)
+}
+
+func newProvisioner(run *runConfig) (TenantProvisioner, error) {
+	switch *provisionerType {
+	case "http":
+		if *adminAuth == "" {
+			return nil, fmt.Errorf("--admin-auth or $%s is required to provision tenants over http. In the cluster it comes from the auth key of the %s secret, see the README", adminAuthEnv, adminAuthSecret)
+		}
+		return &httpProvisioner{
+			adminEndpoint: run.AdminEndpoint,
+			auth:          *adminAuth,
+			tenantURL:     *tenantURL,
+			client:        &http.Client{},
+		}, nil
+	case "memory":
+		return memoryTenants, nil
+	default:
+		return nil, fmt.Errorf("unknown provisioner '%s'", *provisionerType)
+	}
+}
+
+// httpProvisioner talks to the admin api to manage tenants
+type httpProvisioner struct {
+	adminEndpoint string
+	auth          string
+	tenantURL     string
+	client        *http.Client
+}
+
+func (p *httpProvisioner) tenantEndpoint(tenant string) string {
+	url := p.adminEndpoint
+	if !strings.HasSuffix(url, "/") {
+		url = url + "/"
+	}
+	url = url + "tenant"
+	if tenant != "" {
+		url = url + "/" + tenant
+	}
+	return url
+}
+
+// do sends the request and returns the response status and body. Callers
+// check the status.
+func (p *httpProvisioner) do(method, url string, body interface{}, admin bool) (int, []byte, error) {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			fmt.Println("failed to marshal request body")
+			return 0, nil, err
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Printf("Failed to create new request: %v\n", err)
+		return 0, nil, err
+	}
+	if admin {
+		req.Header.Set("Authorization", p.auth)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		fmt.Printf("failed to %s to %s: %v\n", method, url, err)
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Println("failed to read response")
+		return resp.StatusCode, nil, err
+	}
+	fmt.Println("response: ", string(respBody))
+	return resp.StatusCode, respBody, nil
+}
+
+func (p *httpProvisioner) Create(tenant, adminUser string) error {
+	url := p.tenantEndpoint("")
+	fmt.Println("create request to: " + url + " for tenant: " + tenant)
+	body := map[string]interface{}{
+		"tenant": tenant,
+		"user":   adminUser,
+	}
+	status, _, err := p.do("POST", url, body, true)
+	if err != nil {
+		return err
+	}
+	if status < 200 || status >= 300 {
+		fmt.Println("Failed request")
+		return errors.New("Failed to create tenant")
+	}
+	return nil
+}
+
+func (p *httpProvisioner) InitializeAdmin(tenant, domain, adminUser, adminPassword string) error {
+	base := strings.NewReplacer("{tenant}", tenant, "{domain}", domain).Replace(p.tenantURL)
+	body := map[string]interface{}{
+		"username": adminUser,
+		"password": adminPassword,
+	}
+	status, _, err := p.do("POST", strings.TrimSuffix(base, "/")+"/initialize", body, false)
+	if err != nil {
+		return err
+	}
+	if status < 200 || status >= 300 {
+		fmt.Println("Failed request")
+		return errors.New("Failed to initialize tenant admin")
+	}
+	return nil
+}
+
+func (p *httpProvisioner) Delete(tenant string) error {
+	url := p.tenantEndpoint(tenant)
+	fmt.Println("delete request to: " + url)
+	status, _, err := p.do("DELETE", url, nil, true)
+	if err != nil {
+		return err
+	}
+	if status < 200 || status >= 300 {
+		fmt.Println("Failed request")
+		return fmt.Errorf("Failed to delete tenant: status %d", status)
+	}
+	return nil
+}
+
+func (p *httpProvisioner) Exists(tenant string) (bool, error) {
+	status, _, err := p.do("GET", p.tenantEndpoint(tenant), nil, true)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case status == http.StatusNotFound:
+		return false, nil
+	case status >= 200 && status < 300:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unexpected status %d checking for tenant %s", status, tenant)
+	}
+}
+
+// memoryProvisioner is a fake that keeps tenants in memory so setup and
+// teardown can be exercised without an admin api
+type memoryProvisioner struct {
+	sync.Mutex
+	tenants map[string]*memoryTenant
+}
+
+type memoryTenant struct {
+	AdminUser     string
+	AdminPassword string
+	Domain        string
+}
+
+func newMemoryProvisioner() *memoryProvisioner {
+	return &memoryProvisioner{
+		tenants: map[string]*memoryTenant{},
+	}
+}
+
+func (p *memoryProvisioner) Create(tenant, adminUser string) error {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.tenants[tenant]; ok {
+		return fmt.Errorf("tenant %s already exists", tenant)
+	}
+	p.tenants[tenant] = &memoryTenant{AdminUser: adminUser}
+	return nil
+}
+
+func (p *memoryProvisioner) InitializeAdmin(tenant, domain, adminUser, adminPassword string) error {
+	p.Lock()
+	defer p.Unlock()
+	t, ok := p.tenants[tenant]
+	if !ok {
+		return fmt.Errorf("tenant %s does not exist", tenant)
+	}
+	if t.AdminUser != adminUser {
+		return fmt.Errorf("user %s is not the admin of tenant %s", adminUser, tenant)
+	}
+	t.AdminPassword = adminPassword
+	t.Domain = domain
+	return nil
+}
+
+func (p *memoryProvisioner) Delete(tenant string) error {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.tenants, tenant)
+	return nil
+}
+
+func (p *memoryProvisioner) Exists(tenant string) (bool, error) {
+	p.Lock()
+	defer p.Unlock()
+	_, ok := p.tenants[tenant]
+	return ok, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetupAndTeardownWithMemoryProvisioner(t *testing.T) {
+	defer func(previous string) { *provisionerType = previous }(*provisionerType)
+	*provisionerType = "memory"
+
+	run := newRunConfig()
+	run.Tenant = "offline-tenant"
+	run.Domain = "example.com"
+	run.AdminUser = "admin"
+	run.AdminPassword = "secret"
+
+	if err := createRemoteTenant(run); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	tenant, ok := memoryTenants.tenants[run.Tenant]
+	if !ok {
+		t.Fatal("setup did not create the tenant")
+	}
+	if tenant.AdminUser != "admin" || tenant.AdminPassword != "secret" || tenant.Domain != "example.com" {
+		t.Fatalf("setup initialized the admin as %+v", tenant)
+	}
+	if err := createRemoteTenant(run); err == nil {
+		t.Fatal("setup of an existing tenant succeeded")
+	}
+
+	if err := DoTeardown(run); err != nil {
+		t.Fatalf("teardown failed: %v", err)
+	}
+	if exists, _ := memoryTenants.Exists(run.Tenant); exists {
+		t.Fatal("teardown left the tenant")
+	}
+	if err := DoTeardown(run); err != nil {
+		t.Fatalf("teardown of a missing tenant failed: %v", err)
+	}
+}
+
+func TestHTTPProvisionerStatuses(t *testing.T) {
+	tests := []struct {
+		status int
+		ok     bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusNoContent, true},
+		{http.StatusMultipleChoices, false},
+		{http.StatusForbidden, false},
+		{http.StatusInternalServerError, false},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+		}))
+		p := &httpProvisioner{
+			adminEndpoint: server.URL,
+			auth:          "Basic test",
+			tenantURL:     server.URL,
+			client:        server.Client(),
+		}
+		calls := map[string]error{
+			"create":     p.Create("tenant", "admin"),
+			"initialize": p.InitializeAdmin("tenant", "example.com", "admin", "secret"),
+			"delete":     p.Delete("tenant"),
+		}
+		for call, err := range calls {
+			if (err == nil) != test.ok {
+				t.Errorf("%s with status %d: got error %v", call, test.status, err)
+			}
+		}
+		server.Close()
+	}
+}
+
+func TestHTTPProvisionerRequiresAdminAuth(t *testing.T) {
+	defer func(previous string) { *provisionerType = previous }(*provisionerType)
+	defer func(previous string) { *adminAuth = previous }(*adminAuth)
+	*provisionerType = "http"
+	*adminAuth = ""
+	_, err := newProvisioner(newRunConfig())
+	if err == nil {
+		t.Fatal("http provisioner created without --admin-auth")
+	}
+	if !strings.Contains(err.Error(), "loadtest-admin") {
+		t.Fatalf("error doesn't name the secret: %v", err)
+	}
+}
@@ -2,11 +2,8 @@ package main
 
 import (
 	"encoding/json"
-	"math"
 	"strings"
 	"time"
-
-	vegeta "github.com/tsenart/vegeta/lib"
 )
 
 type column struct {
@@ -16,6 +13,8 @@ type column struct {
 }
 
 type row struct {
+	Phase    string  `json:"phase"`
+	Route    string  `json:"route"`
 	Total    float32 `json:"total"`
 	Mean     float32 `json:"mean"`
 	P50th    float32 `json:"p50th"`
@@ -31,135 +30,155 @@ type row struct {
 	Errors      string  `json:"errors"`
 }
 
+// redashData is the redash query result shape. Redash reads the columns and
+// rows; the violations and comparison are there for callers deciding on the
+// run, since the rows alone don't say why it failed.
 type redashData struct {
-	Columns []column `json:"columns"`
-	Rows    []row    `json:"rows"`
+	Columns    []column    `json:"columns"`
+	Rows       []row       `json:"rows"`
+	Violations []violation `json:"violations,omitempty"`
+	Comparison *comparison `json:"comparison,omitempty"`
+}
+
+// vegetaResultsToRedash gives one row for the whole run followed by a row for
+// each phase of the load profile, if it had phases, and a row for each route
+func vegetaResultsToRedash(results []loaderMetrics) *redashData {
+	all := overallMetrics(results)
+	byPhase := map[string][]histogramMetrics{}
+	phaseNames := []string{}
+	for _, r := range results {
+		for _, p := range r.Phases {
+			if _, ok := byPhase[p.Name]; !ok {
+				phaseNames = append(phaseNames, p.Name)
+			}
+			byPhase[p.Name] = append(byPhase[p.Name], p.histogramMetrics)
+		}
+	}
+	rows := []row{metricsToRow("all", "all", all)}
+	for _, name := range phaseNames {
+		rows = append(rows, metricsToRow(name, "all", byPhase[name]))
+	}
+	routeNames, byRoute := routesOf(results)
+	for _, name := range routeNames {
+		rows = append(rows, metricsToRow("all", name, byRoute[name]))
+	}
+	return &redashData{
+		Rows:    rows,
+		Columns: redashColumns,
+	}
 }
 
-func vegetaResultsToRedash(metrics []vegeta.Metrics) *redashData {
-	var requests uint64
-	var mean, p50, p95, p99, max, total, duration, rate float64
+func metricsToRow(phase, route string, metrics []histogramMetrics) row {
+	f := aggregateMetrics(metrics)
 	success := true
 	var err string
-	var statusCodesBytes []byte
-	statusCodes := map[string]int{}
-	var date *time.Time
 	for _, m := range metrics {
-		requests += m.Requests
 		if m.Success != 1.0 {
 			success = false
 		}
-		if date == nil {
-			date = &m.Earliest
-		}
-		if duration == 0 {
-			duration = m.Duration.Seconds()
-		}
-		total += m.Latencies.Total.Seconds() * 1000.0
-		fractionThis := float64(m.Requests) / float64(requests)
-		fractionRest := 1.0 - fractionThis
-		mean = fractionThis*m.Latencies.Mean.Seconds()*1000.0 + fractionRest*mean
-		p50 = fractionThis*m.Latencies.P50.Seconds()*1000.0 + fractionRest*p50
-		p95 = fractionThis*m.Latencies.P95.Seconds()*1000.0 + fractionRest*p95
-		p99 = fractionThis*m.Latencies.P99.Seconds()*1000.0 + fractionRest*p99
-		max = math.Max(fractionThis*m.Latencies.Max.Seconds()*1000.0, max)
-
-		rate += m.Rate
-
 		if len(m.Errors) > 0 {
 			err = err + ", " + strings.Join(m.Errors, ",")
 		}
-		for k, v := range m.StatusCodes {
-			statusCodes[k] += v
-		}
 	}
+	statusCodesBytes, _ := json.Marshal(f.StatusCodes)
 
-	statusCodesBytes, _ = json.Marshal(statusCodes)
-
-	return &redashData{
-		Rows: []row{
-			row{
-				Total:    float32(total),
-				Mean:     float32(mean),
-				P50th:    float32(p50),
-				P95th:    float32(p95),
-				P99th:    float32(p99),
-				Max:      float32(max),
-				Requests: requests,
-				//Date:        *date,
-				Rate:        float32(rate),
-				Duration:    float32(duration),
-				Success:     success,
-				StatusCodes: string(statusCodesBytes),
-				Errors:      err,
-			},
-		},
-		Columns: []column{
-			column{
-				Name:         "total",
-				Type:         "float",
-				FriendlyName: "total",
-			},
-			column{
-				Name:         "mean",
-				Type:         "float",
-				FriendlyName: "mean",
-			},
-			column{
-				Name:         "p50th",
-				Type:         "float",
-				FriendlyName: "p50th",
-			},
-			column{
-				Name:         "p95th",
-				Type:         "float",
-				FriendlyName: "p95th",
-			},
-			column{
-				Name:         "p99th",
-				Type:         "float",
-				FriendlyName: "p99th",
-			},
-			column{
-				Name:         "max",
-				Type:         "float",
-				FriendlyName: "max",
-			},
-			column{
-				Name:         "requests",
-				Type:         "integer",
-				FriendlyName: "requests",
-			},
-			column{
-				Name:         "duration",
-				Type:         "integer",
-				FriendlyName: "duration",
-			},
-			// column{
-			// 	Name: "date",
-			// 	Type: "datetime",
-			// 	FriendlyName: "date",
-			// },
-			column{
-				Name:         "rate",
-				Type:         "float",
-				FriendlyName: "rate",
-			},
-			column{
-				Name:         "success",
-				Type:         "bool",
-				FriendlyName: "success",
-			},
-			column{
-				Name:         "statusCodes",
-				Type:         "string",
-				FriendlyName: "statusCodes",
-			},
-			column{
-				Name:         "errors",
-				Type:         "string",
-				FriendlyName: "errors",
-			},
-		},
+	return row{
+		Phase:    phase,
+		Route:    route,
+		Total:    float32(toMillis(f.Total)),
+		Mean:     float32(toMillis(f.Mean)),
+		P50th:    float32(toMillis(f.P50)),
+		P95th:    float32(toMillis(f.P95)),
+		P99th:    float32(toMillis(f.P99)),
+		Max:      float32(toMillis(f.Max)),
+		Requests: f.Requests,
+		//Date:        *date,
+		Rate:        float32(f.Rate),
+		Duration:    float32(f.Duration.Seconds()),
+		Success:     success,
+		StatusCodes: string(statusCodesBytes),
+		Errors:      err,
 	}
 }
+
+func toMillis(d time.Duration) float64 {
+	return d.Seconds() * 1000.0
+}
+
+var redashColumns = []column{
+	column{
+		Name:         "phase",
+		Type:         "string",
+		FriendlyName: "phase",
+	},
+	column{
+		Name:         "route",
+		Type:         "string",
+		FriendlyName: "route",
+	},
+	column{
+		Name:         "total",
+		Type:         "float",
+		FriendlyName: "total",
+	},
+	column{
+		Name:         "mean",
+		Type:         "float",
+		FriendlyName: "mean",
+	},
+	column{
+		Name:         "p50th",
+		Type:         "float",
+		FriendlyName: "p50th",
+	},
+	column{
+		Name:         "p95th",
+		Type:         "float",
+		FriendlyName: "p95th",
+	},
+	column{
+		Name:         "p99th",
+		Type:         "float",
+		FriendlyName: "p99th",
+	},
+	column{
+		Name:         "max",
+		Type:         "float",
+		FriendlyName: "max",
+	},
+	column{
+		Name:         "requests",
+		Type:         "integer",
+		FriendlyName: "requests",
+	},
+	column{
+		Name:         "duration",
+		Type:         "integer",
+		FriendlyName: "duration",
+	},
+	// column{
+	// 	Name: "date",
+	// 	Type: "datetime",
+	// 	FriendlyName: "date",
+	// },
+	column{
+		Name:         "rate",
+		Type:         "float",
+		FriendlyName: "rate",
+	},
+	column{
+		Name:         "success",
+		Type:         "bool",
+		FriendlyName: "success",
+	},
+	column{
+		Name:         "statusCodes",
+		Type:         "string",
+		FriendlyName: "statusCodes",
+	},
+	column{
+		Name:         "errors",
+		Type:         "string",
+		FriendlyName: "errors",
+	},
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/noahhai/kube-vegeta/formats"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+var recordResults = flag.Bool("record-results", false, "Have loadbots record every result so they can be downloaded from /jobs/{id}/results")
+
+// streamFromLoadbot opens a streamed GET of an endpoint on the loadbot pod.
+// Unlike getFromLoadbot the body isn't buffered, since results can be large.
+func streamFromLoadbot(clientset kubernetes.Interface, pod *corev1.Pod, endpoint string) (io.ReadCloser, error) {
+	if !*useIP {
+		podPath := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s:8080/proxy/%s", pod.Namespace, pod.Name, endpoint)
+		return clientset.CoreV1().RESTClient().Get().AbsPath(podPath).Stream()
+	}
+	resp, err := http.Get("http://" + pod.Status.PodIP + ":8080/" + endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("loadbot %s returned %d", pod.Name, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// resultStream is one loadbot's results with the next one read ahead
+type resultStream struct {
+	decode vegeta.Decoder
+	next   vegeta.Result
+	done   bool
+}
+
+func (s *resultStream) advance() {
+	s.next = vegeta.Result{}
+	if err := s.decode.Decode(&s.next); err != nil {
+		if err != io.EOF {
+			fmt.Printf("Error decoding results: %v\n", err)
+		}
+		s.done = true
+	}
+}
+
+// mergeResults writes the results of every stream in timestamp order. Each
+// loadbot records its results in order so only the heads need comparing.
+func mergeResults(streams []*resultStream, encode vegeta.Encoder) error {
+	for _, s := range streams {
+		s.advance()
+	}
+	for {
+		var earliest *resultStream
+		for _, s := range streams {
+			if !s.done && (earliest == nil || s.next.Timestamp.Before(earliest.next.Timestamp)) {
+				earliest = s
+			}
+		}
+		if earliest == nil {
+			return nil
+		}
+		if err := encode.Encode(&earliest.next); err != nil {
+			return err
+		}
+		earliest.advance()
+	}
+}
+
+// serveJobResults handles GET /jobs/{id}/results?format= by merging the results
+// every loadbot recorded for the job into one bin, csv or json file
+func serveJobResults(w http.ResponseWriter, r *http.Request, j *job) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "bin"
+	}
+	buffer := bufio.NewWriter(w)
+	encode, err := formats.NewResultsEncoder(format, buffer)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	clientset, loadbots, err := listLoadbots(j.selector)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+	streams := []*resultStream{}
+	endpoint := "results?format=bin&run=" + url.QueryEscape(j.ID)
+	for _, pod := range loadbots {
+		body, err := streamFromLoadbot(clientset, pod, endpoint)
+		if err != nil {
+			// loadbots that didn't take part have nothing recorded
+			fmt.Printf("no results from loadbot %s: %v\n", pod.Name, err)
+			continue
+		}
+		defer body.Close()
+		streams = append(streams, &resultStream{decode: vegeta.NewDecoder(bufio.NewReader(body))})
+	}
+	if len(streams) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Error: no loadbot has results recorded for job " + j.ID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"results-%s.%s\"", j.ID, format))
+	if err := mergeResults(streams, encode); err != nil {
+		// the response has started so all we can do is stop
+		fmt.Printf("Error merging results: %v\n", err)
+	}
+	buffer.Flush()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// recordedStream is a loadbot's recording of results sent at the offsets
+func recordedStream(t *testing.T, began time.Time, offsets ...int) *resultStream {
+	var buffer bytes.Buffer
+	encode := vegeta.NewEncoder(&buffer)
+	for _, offset := range offsets {
+		res := vegeta.Result{
+			Attack:    "main",
+			Code:      200,
+			Timestamp: began.Add(time.Duration(offset) * time.Millisecond),
+		}
+		if err := encode.Encode(&res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &resultStream{decode: vegeta.NewDecoder(&buffer)}
+}
+
+func TestMergeResultsInTimestampOrder(t *testing.T) {
+	began := time.Now()
+	tests := []struct {
+		name    string
+		streams [][]int
+		merged  []int
+	}{
+		{"no loadbots", [][]int{}, []int{}},
+		{"one loadbot", [][]int{{1, 2, 3}}, []int{1, 2, 3}},
+		{"interleaved", [][]int{{1, 3, 5}, {2, 4, 6}}, []int{1, 2, 3, 4, 5, 6}},
+		{"one runs out first", [][]int{{1, 2}, {3, 4, 5, 6, 7}}, []int{1, 2, 3, 4, 5, 6, 7}},
+		{"uneven lengths", [][]int{{5}, {1, 2, 3, 4, 6, 9}, {7, 8}}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"one recorded nothing", [][]int{{}, {2, 4}, {1, 3}}, []int{1, 2, 3, 4}},
+		{"all recorded nothing", [][]int{{}, {}}, []int{}},
+		{"equal timestamps", [][]int{{1, 2}, {1, 2}}, []int{1, 1, 2, 2}},
+	}
+	for _, test := range tests {
+		streams := []*resultStream{}
+		for _, offsets := range test.streams {
+			streams = append(streams, recordedStream(t, began, offsets...))
+		}
+		merged := []int{}
+		encode := func(res *vegeta.Result) error {
+			merged = append(merged, int(res.Timestamp.Sub(began)/time.Millisecond))
+			return nil
+		}
+		if err := mergeResults(streams, encode); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if fmt.Sprint(merged) != fmt.Sprint(test.merged) {
+			t.Errorf("%s: merged %v, want %v", test.name, merged, test.merged)
+		}
+	}
+}
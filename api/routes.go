@@ -0,0 +1,37 @@
+package main
+
+// routeMetrics are a loadbot's metrics for the requests to one route, e.g. an
+// operation of the workload, a template or a replayed path
+type routeMetrics struct {
+	Name string `json:"name"`
+	histogramMetrics
+}
+
+// routesOf gathers every loadbot's metrics for each route, with the route
+// names in the order they were first seen
+func routesOf(results []loaderMetrics) ([]string, map[string][]histogramMetrics) {
+	names := []string{}
+	byRoute := map[string][]histogramMetrics{}
+	for _, r := range results {
+		for _, route := range r.Routes {
+			if _, ok := byRoute[route.Name]; !ok {
+				names = append(names, route.Name)
+			}
+			byRoute[route.Name] = append(byRoute[route.Name], route.histogramMetrics)
+		}
+	}
+	return names, byRoute
+}
+
+// fleetRoutes combines the loadbots' metrics of each route
+func fleetRoutes(results []loaderMetrics) map[string]*fleetMetrics {
+	names, byRoute := routesOf(results)
+	if len(names) == 0 {
+		return nil
+	}
+	routes := make(map[string]*fleetMetrics, len(names))
+	for _, name := range names {
+		routes[name] = aggregateMetrics(byRoute[name])
+	}
+	return routes
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/icrowley/fake"
+)
+
+const baseCliConfig = ".thy.yml"
+
+// runConfig is everything a single operation needs. It is built once per cli
+// invocation or per request in serve mode and threaded through setup, load and
+// teardown so concurrent runs never share state through the global flags.
+type runConfig struct {
+	ID                string
+	Tenant            string
+	AdminEndpoint     string
+	AdminUser         string
+	AdminPassword     string
+	Redash            bool
+	Domain            string
+	Operation         string
+	NumberUsers       int
+	NumberSecrets     int
+	NumberPermissions int
+	SecretLength      int
+	CliVersion        string
+	Executor          string
+	LoadDuration      int
+	LoadRate          int
+	LoadPhases        []loadPhase
+	Selector          string
+	Thresholds        *thresholds
+	Scenario          string
+	Baseline          string
+	SaveBaseline      string
+	FailOnRegression  bool
+	Tolerances        tolerances
+	RecordResults     bool
+	Workload          map[string]int
+	BodySize          int
+	Targets           string
+	LoopTargets       bool
+	RandomTargets     bool
+	Templates         []targetTemplate
+	VirtualUsers      int
+	ThinkTime         time.Duration
+	ThinkJitter       time.Duration
+	GRPC              *grpcConfig
+
+	// binaryName is the cli executable to shell out to for this run
+	binaryName string
+	// cliConfig is the cli config file owned by this run
+	cliConfig string
+	// targetsFile is a local file of targets to import before a cli run
+	targetsFile string
+	// commands are the staged setup commands built by prepareDataLocally
+	commands SyncCommandSet
+	// job is set when the run was started through /command in serve mode
+	job *job
+	// ctx is cancelled when the run is cancelled
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newRunConfig snapshots the current flag values into a fresh runConfig
+func newRunConfig() *runConfig {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &runConfig{
+		ID:                newRunID(),
+		ctx:               ctx,
+		cancel:            cancel,
+		Tenant:            *tenant,
+		AdminEndpoint:     *adminEndpoint,
+		AdminUser:         *adminUser,
+		Redash:            *redash,
+		Domain:            *domain,
+		Operation:         *operation,
+		NumberUsers:       *numberUsers,
+		NumberSecrets:     *numberSecrets,
+		NumberPermissions: *numberPermissions,
+		SecretLength:      *secretLength,
+		CliVersion:        *cliVersion,
+		Executor:          *executorType,
+		LoadDuration:      *loadDuration,
+		LoadRate:          *loadRate,
+		Selector:          *selector,
+		Baseline:          *baseline,
+		SaveBaseline:      *saveBaseline,
+		FailOnRegression:  *failOnRegression,
+		RecordResults:     *recordResults,
+		Workload:          *workloadWeights,
+		BodySize:          *bodySize,
+		LoopTargets:       *loopTargets,
+		RandomTargets:     *randomTargets,
+		VirtualUsers:      *virtualUsers,
+		ThinkTime:         *thinkTime,
+		ThinkJitter:       *thinkJitter,
+		Tolerances: tolerances{
+			Latency:    *latencyTolerance,
+			Success:    *successTolerance,
+			Throughput: *throughputTolerance,
+		},
+	}
+}
+
+func preRun(run *runConfig) {
+	// TODO : update this as we change it
+	if run.AdminPassword == "" {
+		run.AdminPassword = run.AdminUser + "@1" + run.AdminUser + "@1"
+	}
+	run.Tenant = strings.ToLower(run.Tenant)
+	if run.Tenant == "" {
+		run.Tenant = randomTenantName()
+		fmt.Println("blank tenant name. generated random: " + run.Tenant)
+	}
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// cancelled reports whether the run was cancelled
+func (run *runConfig) cancelled() bool {
+	return run.ctx.Err() != nil
+}
+
+func randomTenantName() string {
+	return strings.Replace(strings.ToLower(fake.Company()), " ", "-", -1)
+}
+
+// prepareCliConfig gives the run its own copy of the cli config so that the
+// config commands issued during setup don't race with other runs
+func (run *runConfig) prepareCliConfig() error {
+	if run.Executor != "cli" {
+		return nil
+	}
+	// keyed by the generated run ID rather than the tenant, which concurrent
+	// runs can share and which comes from the request
+	run.cliConfig = fmt.Sprintf(".thy.%s.yml", run.ID)
+	base, err := ioutil.ReadFile(path.Join(currDir, baseCliConfig))
+	if err != nil && !os.IsNotExist(err) {
+		return logAndError(fmt.Sprintf("failed to read base cli config: %v", err))
+	}
+	if err := ioutil.WriteFile(path.Join(currDir, run.cliConfig), base, 0600); err != nil {
+		return logAndError(fmt.Sprintf("failed to write cli config for run: %v", err))
+	}
+	return nil
+}
+
+func (run *runConfig) cleanupCliConfig() {
+	if run.cliConfig == "" {
+		return
+	}
+	if err := os.Remove(path.Join(currDir, run.cliConfig)); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("failed to remove cli config %s: %v\n", run.cliConfig, err)
+	}
+}
+
+// setState reports progress to the run's job, if it has one
+func (run *runConfig) setState(state string) {
+	if run.job != nil {
+		run.job.setState(state)
+	}
+}
+
+// setStage reports the current setup stage to the run's job, if it has one
+func (run *runConfig) setStage(stage string) {
+	if run.job != nil {
+		run.job.setStage(stage)
+	}
+}
+
+// ensureCli makes sure the cli is available for runs that set up through it
+func (run *runConfig) ensureCli() error {
+	if run.Executor != "cli" || run.Operation == "teardown" || run.Operation == "test" {
+		return nil
+	}
+	cli, err := ensureCliDownloaded(run.CliVersion)
+	if err != nil {
+		return err
+	}
+	run.binaryName = cli
+	return nil
+}
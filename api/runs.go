@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	runConfigMapKey   = "run.json"
+	runConfigMapLabel = "loadtest-run"
+	// the labels runs are looked up by, holding the label value of the
+	// scenario and baseline names and whether the run passed
+	runScenarioLabel = "loadtest-scenario"
+	runBaselineLabel = "loadtest-baseline"
+	runPassedLabel   = "loadtest-passed"
+)
+
+var (
+	runStoreType = flag.String("run-store", "file", "Where the metrics of finished runs are kept for baseline comparison [file|configmap]")
+	runDir       = flag.String("run-dir", ".runs", "Directory for run metrics when --run-store=file")
+	runsRetained = flag.Int("runs-retained", 20, "Runs kept per scenario. Older ones are removed as runs finish, except the latest passing run and the latest run of each baseline. 0 keeps every run")
+
+	runs runStore
+)
+
+// runRecord is the aggregated outcome of one load test
+type runRecord struct {
+	ID       string
+	Scenario string `json:",omitempty"`
+	// Baseline is the name the run was saved as a baseline under, if any
+	Baseline string `json:",omitempty"`
+	Tenant   string
+	Passed   bool
+	Finished time.Time
+	Metrics  *fleetMetrics
+}
+
+// runFilter picks records by scenario, baseline name and outcome. Empty
+// fields match any record.
+type runFilter struct {
+	Scenario string
+	Baseline string
+	// Unnamed only matches records without a scenario
+	Unnamed bool
+	Passed  bool
+}
+
+func (f runFilter) matches(record *runRecord) bool {
+	if f.Unnamed && record.Scenario != "" {
+		return false
+	}
+	return (f.Scenario == "" || record.Scenario == f.Scenario) &&
+		(f.Baseline == "" || record.Baseline == f.Baseline) &&
+		(!f.Passed || record.Passed)
+}
+
+// runStore persists run records so later runs can be compared against them
+type runStore interface {
+	// Save stores the record, replacing any previous one with the same id
+	Save(record *runRecord) error
+	// Load returns the record with the id, or nil if there is none
+	Load(id string) (*runRecord, error)
+	// List returns the records matching the filter, oldest first
+	List(filter runFilter) ([]*runRecord, error)
+	// Delete removes the record with the id, if there is one
+	Delete(id string) error
+}
+
+func newRunStore(storeType string) (runStore, error) {
+	switch storeType {
+	case "file":
+		return &fileRunStore{dir: *runDir}, nil
+	case "configmap":
+		return newConfigMapRunStore(*modelNamespace)
+	default:
+		return nil, fmt.Errorf("unknown run store '%s'", storeType)
+	}
+}
+
+func sortRuns(records []*runRecord) []*runRecord {
+	sort.Slice(records, func(a, b int) bool { return records[a].Finished.Before(records[b].Finished) })
+	return records
+}
+
+// fileRunStore keeps one json file per run in a local directory
+type fileRunStore struct {
+	dir string
+}
+
+func (s *fileRunStore) path(id string) string {
+	return path.Join(s.dir, modelName(id)+".json")
+}
+
+func (s *fileRunStore) Save(record *runRecord) error {
+	asBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	tmp := s.path(record.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, asBytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(record.ID))
+}
+
+func (s *fileRunStore) Load(id string) (*runRecord, error) {
+	return s.read(s.path(id))
+}
+
+func (s *fileRunStore) read(file string) (*runRecord, error) {
+	asBytes, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var record runRecord
+	if err := json.Unmarshal(asBytes, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *fileRunStore) List(filter runFilter) ([]*runRecord, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []*runRecord{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	records := []*runRecord{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		record, err := s.read(path.Join(s.dir, f.Name()))
+		if err != nil {
+			fmt.Printf("skipping unreadable run %s: %v\n", f.Name(), err)
+			continue
+		}
+		if record != nil && filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+	return sortRuns(records), nil
+}
+
+func (s *fileRunStore) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// configMapRunStore keeps each run in a kubernetes ConfigMap so baselines
+// survive api pod restarts
+type configMapRunStore struct {
+	namespace string
+	clientset kubernetes.Interface
+}
+
+func newConfigMapRunStore(namespace string) (*configMapRunStore, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		fmt.Printf("Error creating config: %v", err)
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		fmt.Printf("Error client: %v", err)
+		return nil, err
+	}
+	return &configMapRunStore{
+		namespace: namespace,
+		clientset: clientset,
+	}, nil
+}
+
+func (s *configMapRunStore) name(id string) string {
+	return "loadtest-run-" + modelName(id)
+}
+
+func (s *configMapRunStore) Save(record *runRecord) error {
+	asBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: s.name(record.ID),
+			Labels: map[string]string{
+				"app":             "api",
+				runConfigMapLabel: "true",
+				runScenarioLabel:  runLabelValue(record.Scenario),
+				runBaselineLabel:  runLabelValue(record.Baseline),
+				runPassedLabel:    fmt.Sprint(record.Passed),
+			},
+		},
+		Data: map[string]string{
+			runConfigMapKey: string(asBytes),
+		},
+	}
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+	_, err = configMaps.Create(configMap)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(configMap)
+	}
+	return err
+}
+
+func (s *configMapRunStore) Load(id string) (*runRecord, error) {
+	configMap, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(s.name(id), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return s.decode(configMap)
+}
+
+func (s *configMapRunStore) decode(configMap *corev1.ConfigMap) (*runRecord, error) {
+	data, ok := configMap.Data[runConfigMapKey]
+	if !ok {
+		return nil, fmt.Errorf("configmap %s has no %s key", configMap.Name, runConfigMapKey)
+	}
+	var record runRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// List only decodes the runs whose labels match the filter. Label values
+// can collide, so the decoded records are checked against it again.
+func (s *configMapRunStore) List(filter runFilter) ([]*runRecord, error) {
+	selector := []string{runConfigMapLabel + "=true"}
+	if filter.Scenario != "" || filter.Unnamed {
+		selector = append(selector, runScenarioLabel+"="+runLabelValue(filter.Scenario))
+	}
+	if filter.Baseline != "" {
+		selector = append(selector, runBaselineLabel+"="+runLabelValue(filter.Baseline))
+	}
+	if filter.Passed {
+		selector = append(selector, runPassedLabel+"=true")
+	}
+	configMaps, err := s.clientset.CoreV1().ConfigMaps(s.namespace).List(metav1.ListOptions{
+		LabelSelector: strings.Join(selector, ","),
+	})
+	if err != nil {
+		return nil, err
+	}
+	records := []*runRecord{}
+	for ix := range configMaps.Items {
+		record, err := s.decode(&configMaps.Items[ix])
+		if err != nil {
+			fmt.Printf("skipping unreadable run %s: %v\n", configMaps.Items[ix].Name, err)
+			continue
+		}
+		if filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+	return sortRuns(records), nil
+}
+
+func (s *configMapRunStore) Delete(id string) error {
+	err := s.clientset.CoreV1().ConfigMaps(s.namespace).Delete(s.name(id), &metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// runLabelValue fits a scenario or baseline name into a label value
+func runLabelValue(name string) string {
+	value := modelName(name)
+	if len(value) > 63 {
+		sum := sha256.Sum256([]byte(name))
+		value = strings.Trim(value[:54], "-") + "-" + hex.EncodeToString(sum[:4])
+	}
+	return value
+}
+
+// pruneRuns removes the oldest runs of the scenario beyond --runs-retained.
+// The latest passing run and the latest run of each baseline are kept since
+// later runs are compared against them.
+func pruneRuns(scenarioName string) {
+	if *runsRetained < 1 {
+		return
+	}
+	records, err := runs.List(runFilter{Scenario: scenarioName, Unnamed: scenarioName == ""})
+	if err != nil {
+		fmt.Printf("failed to list runs to prune: %v\n", err)
+		return
+	}
+	kept := 0
+	keptPassed := false
+	keptBaselines := map[string]bool{}
+	for ix := len(records) - 1; ix >= 0; ix-- {
+		r := records[ix]
+		keep := kept < *runsRetained
+		if r.Passed && !keptPassed {
+			keep, keptPassed = true, true
+		}
+		if r.Baseline != "" && !keptBaselines[r.Baseline] {
+			keep, keptBaselines[r.Baseline] = true, true
+		}
+		if keep {
+			kept++
+			continue
+		}
+		if err := runs.Delete(r.ID); err != nil {
+			fmt.Printf("failed to prune run %s: %v\n", r.ID, err)
+		}
+	}
+}
+
+// findBaseline picks what a run is compared against: the latest run saved
+// under the named baseline, or failing a name the last run of the same
+// scenario that passed
+func findBaseline(name, scenarioName, excludeID string) (*runRecord, error) {
+	if name == "" && scenarioName == "" {
+		return nil, nil
+	}
+	filter := runFilter{Baseline: name}
+	if name == "" {
+		filter = runFilter{Scenario: scenarioName, Passed: true}
+	}
+	records, err := runs.List(filter)
+	if err != nil {
+		return nil, err
+	}
+	for ix := len(records) - 1; ix >= 0; ix-- {
+		r := records[ix]
+		if r.ID != excludeID && r.Metrics != nil {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+// serveRuns handles GET /runs and GET /runs/{id}. /runs can be filtered with
+// ?scenario= and ?baseline=
+func serveRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs"), "/")
+	if id != "" {
+		record, err := runs.Load(id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Error: " + err.Error()))
+			return
+		}
+		if record == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Error: no run with id " + id))
+			return
+		}
+		writeJSON(w, http.StatusOK, record)
+		return
+	}
+
+	records, err := runs.List(runFilter{
+		Scenario: r.URL.Query().Get("scenario"),
+		Baseline: r.URL.Query().Get("baseline"),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+	writeJSON(w, http.StatusOK, records)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapRunStoreFindsBaselinesAndPrunes(t *testing.T) {
+	defer func(previous runStore, retained int) { runs, *runsRetained = previous, retained }(runs, *runsRetained)
+	store := &configMapRunStore{namespace: "default", clientset: fake.NewSimpleClientset()}
+	runs = store
+	*runsRetained = 3
+
+	began := time.Now()
+	save := func(id, scenario, baseline string, passed bool) {
+		record := &runRecord{
+			ID:       id,
+			Scenario: scenario,
+			Baseline: baseline,
+			Passed:   passed,
+			Finished: began.Add(time.Duration(len(id)) * time.Minute),
+			Metrics:  &fleetMetrics{},
+		}
+		if err := runs.Save(record); err != nil {
+			t.Fatalf("saving %s failed: %v", id, err)
+		}
+		pruneRuns(scenario)
+	}
+	// ids grow so each run finishes after the last
+	save("r", "checkout", "release", true)
+	save("rr", "checkout", "", true)
+	save("rrr", "Checkout!", "", true)
+	for ix := 4; ix <= 8; ix++ {
+		save(fmt.Sprintf("%0*d", ix, 0), "checkout", "", false)
+	}
+
+	all, err := runs.List(runFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, r := range all {
+		ids = append(ids, r.ID)
+	}
+	// 3 failing runs of checkout, its latest passing run and its baseline,
+	// and the run of the other scenario
+	if fmt.Sprint(ids) != "[r rr rrr 000000 0000000 00000000]" {
+		t.Fatalf("kept %v", ids)
+	}
+
+	tests := []struct {
+		baseline, scenario, exclude string
+		want                        string
+	}{
+		{"release", "", "", "r"},
+		{"release", "checkout", "r", ""},
+		{"", "checkout", "", "rr"},
+		{"", "Checkout!", "", "rrr"},
+		{"", "Checkout!", "rrr", ""},
+		{"missing", "checkout", "", ""},
+	}
+	for _, test := range tests {
+		found, err := findBaseline(test.baseline, test.scenario, test.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if found != nil {
+			got = found.ID
+		}
+		if got != test.want {
+			t.Errorf("baseline %q of scenario %q excluding %q is %q, want %q", test.baseline, test.scenario, test.exclude, got, test.want)
+		}
+	}
+}
+
+func TestRunLabelValueFits(t *testing.T) {
+	long := ""
+	for len(long) < 100 {
+		long += "scenario"
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", ""},
+		{"checkout", "checkout"},
+		{long, long[:54] + "-"},
+		{long + "2", long[:54] + "-"},
+	}
+	seen := map[string]bool{}
+	for _, test := range tests {
+		got := runLabelValue(test.name)
+		if len(got) > 63 || got[:len(test.want)] != test.want || seen[got] {
+			t.Errorf("label value of %q is %q, want a new value starting %q", test.name, got, test.want)
+		}
+		seen[got] = true
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	flag "github.com/spf13/pflag"
+)
+
+var scenarioFile = flag.String("scenario", "", "Path to a yaml scenario file describing the run. Flags given explicitly take precedence over it")
+
+// scenario is a declarative description of a run, kept in yaml so it can be
+// checked in and reviewed. Any field left out falls back to the flag default.
+type scenario struct {
+	Name      string `json:"name"`
+	Operation string `json:"operation"`
+	Tenant    string `json:"tenant"`
+	Domain    string `json:"domain"`
+
+	Setup struct {
+		Executor   string `json:"executor"`
+		CliVersion string `json:"cliVersion"`
+	} `json:"setup"`
+
+	Data struct {
+		Users        int `json:"users"`
+		Secrets      int `json:"secrets"`
+		Permissions  int `json:"permissions"`
+		SecretLength int `json:"secretLength"`
+	} `json:"data"`
+
+	Load struct {
+		Rate     int             `json:"rate"`
+		Duration string          `json:"duration"`
+		Phases   []scenarioPhase `json:"phases"`
+		// Mix weights the operations of the workload, e.g. read: 80
+		Mix      map[string]int `json:"mix"`
+		BodySize int            `json:"bodySize"`
+		// Targets replays imported requests instead of reading secrets
+		Targets struct {
+			// ID of targets POSTed to /targets
+			ID string `json:"id"`
+			// File is a local har or jsonl file, for cli runs
+			File   string `json:"file"`
+			Loop   *bool  `json:"loop"`
+			Random *bool  `json:"random"`
+		} `json:"targets"`
+		// Templates generate requests to any endpoint instead of reading secrets
+		Templates []targetTemplate `json:"templates"`
+		// VirtualUsers each send a request, wait for the response and think
+		// before the next, instead of keeping to a rate
+		VirtualUsers int    `json:"virtualUsers"`
+		ThinkTime    string `json:"thinkTime"`
+		ThinkJitter  string `json:"thinkJitter"`
+		// GRPC calls a gRPC method instead of the tenant's http api
+		GRPC *grpcConfig `json:"grpc"`
+	} `json:"load"`
+
+	Loadbots struct {
+		Selector string `json:"selector"`
+	} `json:"loadbots"`
+
+	Output struct {
+		Format string `json:"format"`
+		// Results has loadbots record every result for download
+		Results *bool `json:"results"`
+	} `json:"output"`
+
+	Thresholds thresholds `json:"thresholds"`
+
+	Baseline struct {
+		// Name is the baseline to compare against. Without it the run is
+		// compared to the last passing run of the scenario.
+		Name             string              `json:"name"`
+		Save             string              `json:"save"`
+		FailOnRegression *bool               `json:"failOnRegression"`
+		Tolerances       *toleranceOverrides `json:"tolerances"`
+	} `json:"baseline"`
+}
+
+// scenarioPhase is a loadPhase with human readable durations
+type scenarioPhase struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Duration string  `json:"duration"`
+	Rate     float64 `json:"rate"`
+	From     float64 `json:"from"`
+	To       float64 `json:"to"`
+	Steps    int     `json:"steps"`
+	Recovery string  `json:"recovery"`
+}
+
+func (p *scenarioPhase) loadPhase() (loadPhase, error) {
+	d, err := time.ParseDuration(p.Duration)
+	if err != nil {
+		return loadPhase{}, fmt.Errorf("duration '%s' is not a duration like 30s or 10m", p.Duration)
+	}
+	var recovery time.Duration
+	if p.Recovery != "" {
+		if recovery, err = time.ParseDuration(p.Recovery); err != nil {
+			return loadPhase{}, fmt.Errorf("recovery '%s' is not a duration like 30s or 10m", p.Recovery)
+		}
+	}
+	return loadPhase{
+		Name:     p.Name,
+		Type:     p.Type,
+		Duration: int(d.Seconds()),
+		Rate:     p.Rate,
+		From:     p.From,
+		To:       p.To,
+		Steps:    p.Steps,
+		Recovery: int(recovery.Seconds()),
+	}, nil
+}
+
+func loadScenarioFile(path string) (*scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario %s: %v", path, err)
+	}
+	return parseScenario(data)
+}
+
+// parseScenario decodes and validates a yaml scenario. Unknown fields are
+// rejected so that typos don't silently fall back to defaults.
+func parseScenario(data []byte) (*scenario, error) {
+	asJSON, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("scenario is not valid yaml: %v", err)
+	}
+	var s scenario
+	decoder := json.NewDecoder(bytes.NewReader(asJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %v", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Validate checks every field and reports all problems at once
+func (s *scenario) Validate() error {
+	problems := []string{}
+	addProblem := func(field, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	switch s.Operation {
+	case "", "setup", "teardown", "test", "full":
+	default:
+		addProblem("operation", "must be one of setup, teardown, test or full, got '%s'", s.Operation)
+	}
+	switch s.Setup.Executor {
+	case "", "cli", "http":
+	default:
+		addProblem("setup.executor", "must be cli or http, got '%s'", s.Setup.Executor)
+	}
+	if s.Data.Users < 0 {
+		addProblem("data.users", "must not be negative")
+	}
+	if s.Data.Secrets < 0 {
+		addProblem("data.secrets", "must not be negative")
+	}
+	if s.Data.Permissions < 0 {
+		addProblem("data.permissions", "must not be negative")
+	}
+	if s.Data.SecretLength < 0 {
+		addProblem("data.secretLength", "must not be negative")
+	}
+	if s.Load.Rate < 0 {
+		addProblem("load.rate", "must not be negative")
+	}
+	if s.Load.Duration != "" {
+		if d, err := time.ParseDuration(s.Load.Duration); err != nil {
+			addProblem("load.duration", "'%s' is not a duration like 30s or 10m", s.Load.Duration)
+		} else if d < time.Second || d > 3000*time.Second {
+			addProblem("load.duration", "must be between 1s and 3000s, got %s", d)
+		}
+	}
+	if len(s.Load.Phases) > 0 && (s.Load.Rate != 0 || s.Load.Duration != "") {
+		addProblem("load", "use either rate and duration or phases, not both")
+	}
+	for _, problem := range validateWorkload(s.Load.Mix) {
+		addProblem("load.mix", "%s", problem)
+	}
+	if s.Load.BodySize < 0 {
+		addProblem("load.bodySize", "must not be negative")
+	}
+	if s.Load.Targets.ID != "" && s.Load.Targets.File != "" {
+		addProblem("load.targets", "use either id or file, not both")
+	}
+	if (s.Load.Targets.ID != "" || s.Load.Targets.File != "") && len(s.Load.Templates) > 0 {
+		addProblem("load", "use either targets or templates, not both")
+	}
+	if s.Load.VirtualUsers < 0 {
+		addProblem("load.virtualUsers", "must not be negative")
+	}
+	if s.Load.VirtualUsers > 0 && (s.Load.Rate != 0 || len(s.Load.Phases) > 0) {
+		addProblem("load", "use either virtualUsers or a rate or phases, not both")
+	}
+	for field, value := range map[string]string{"load.thinkTime": s.Load.ThinkTime, "load.thinkJitter": s.Load.ThinkJitter} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil {
+			addProblem(field, "'%s' is not a duration like 500ms or 2s", value)
+		} else if d < 0 {
+			addProblem(field, "must not be negative")
+		}
+	}
+	for _, problem := range s.Load.GRPC.validate() {
+		addProblem("load.grpc", "%s", problem)
+	}
+	if s.Load.GRPC != nil && (s.Load.Targets.ID != "" || s.Load.Targets.File != "" || len(s.Load.Templates) > 0 || s.Load.VirtualUsers > 0) {
+		addProblem("load.grpc", "can't be combined with targets, templates or virtualUsers")
+	}
+	for _, problem := range validateTemplates(s.Load.Templates) {
+		addProblem("load.templates", "%s", problem)
+	}
+	total := 0
+	for ix := range s.Load.Phases {
+		field := fmt.Sprintf("load.phases[%d]", ix)
+		phase, err := s.Load.Phases[ix].loadPhase()
+		if err != nil {
+			addProblem(field, "%v", err)
+			continue
+		}
+		for _, problem := range phase.validate() {
+			addProblem(field, "%s", problem)
+		}
+		total += phase.length()
+	}
+	if total > 3000 {
+		addProblem("load.phases", "must add up to at most 3000s, got %ds", total)
+	}
+	for _, problem := range s.Thresholds.validate() {
+		addProblem("thresholds", "%s", problem)
+	}
+	for _, problem := range s.Baseline.Tolerances.validate() {
+		addProblem("baseline", "%s", problem)
+	}
+	if s.Loadbots.Selector != "" {
+		if err := validSelector(s.Loadbots.Selector); err != nil {
+			addProblem("loadbots.selector", "%v", err)
+		}
+	}
+	switch s.Output.Format {
+	case "", "json", "redash":
+	default:
+		addProblem("output.format", "must be json or redash, got '%s'", s.Output.Format)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid scenario:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Apply overrides the run's values with those set in the scenario
+func (s *scenario) Apply(run *runConfig) {
+	if s.Name != "" {
+		run.Scenario = s.Name
+	}
+	if s.Operation != "" {
+		run.Operation = s.Operation
+	}
+	if s.Tenant != "" {
+		run.Tenant = s.Tenant
+	}
+	if s.Domain != "" {
+		run.Domain = s.Domain
+	}
+	if s.Setup.Executor != "" {
+		run.Executor = s.Setup.Executor
+	}
+	if s.Setup.CliVersion != "" {
+		run.CliVersion = s.Setup.CliVersion
+	}
+	if s.Data.Users > 0 {
+		run.NumberUsers = s.Data.Users
+	}
+	if s.Data.Secrets > 0 {
+		run.NumberSecrets = s.Data.Secrets
+	}
+	if s.Data.Permissions > 0 {
+		run.NumberPermissions = s.Data.Permissions
+	}
+	if s.Data.SecretLength > 0 {
+		run.SecretLength = s.Data.SecretLength
+	}
+	if s.Load.Rate > 0 {
+		run.LoadRate = s.Load.Rate
+	}
+	if s.Load.Duration != "" {
+		// already validated
+		d, _ := time.ParseDuration(s.Load.Duration)
+		run.LoadDuration = int(d.Seconds())
+	}
+	if len(s.Load.Phases) > 0 {
+		run.LoadPhases = make([]loadPhase, len(s.Load.Phases))
+		for ix := range s.Load.Phases {
+			// already validated
+			run.LoadPhases[ix], _ = s.Load.Phases[ix].loadPhase()
+		}
+	}
+	if len(s.Load.Mix) > 0 {
+		run.Workload = s.Load.Mix
+	}
+	if s.Load.BodySize > 0 {
+		run.BodySize = s.Load.BodySize
+	}
+	if s.Load.Targets.ID != "" {
+		run.Targets = s.Load.Targets.ID
+	}
+	if s.Load.Targets.File != "" {
+		run.targetsFile = s.Load.Targets.File
+	}
+	if s.Load.Targets.Loop != nil {
+		run.LoopTargets = *s.Load.Targets.Loop
+	}
+	if s.Load.Targets.Random != nil {
+		run.RandomTargets = *s.Load.Targets.Random
+	}
+	if len(s.Load.Templates) > 0 {
+		run.Templates = s.Load.Templates
+	}
+	if s.Load.GRPC != nil {
+		run.GRPC = s.Load.GRPC
+	}
+	if s.Load.VirtualUsers > 0 {
+		run.VirtualUsers = s.Load.VirtualUsers
+	}
+	if s.Load.ThinkTime != "" {
+		// already validated
+		run.ThinkTime, _ = time.ParseDuration(s.Load.ThinkTime)
+	}
+	if s.Load.ThinkJitter != "" {
+		run.ThinkJitter, _ = time.ParseDuration(s.Load.ThinkJitter)
+	}
+	if s.Loadbots.Selector != "" {
+		run.Selector = s.Loadbots.Selector
+	}
+	if s.Output.Format != "" {
+		run.Redash = s.Output.Format == "redash"
+	}
+	if s.Output.Results != nil {
+		run.RecordResults = *s.Output.Results
+	}
+	if !s.Thresholds.empty() {
+		t := s.Thresholds
+		run.Thresholds = &t
+	}
+	if s.Baseline.Name != "" {
+		run.Baseline = s.Baseline.Name
+	}
+	if s.Baseline.Save != "" {
+		run.SaveBaseline = s.Baseline.Save
+	}
+	if s.Baseline.FailOnRegression != nil {
+		run.FailOnRegression = *s.Baseline.FailOnRegression
+	}
+	s.Baseline.Tolerances.apply(&run.Tolerances)
+}
+
+// applyExplicitFlags re-applies flags given on the command line so they take
+// precedence over a scenario file
+func applyExplicitFlags(run *runConfig) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "tenant":
+			run.Tenant = *tenant
+		case "admin-endpoint":
+			run.AdminEndpoint = *adminEndpoint
+		case "admin-user":
+			run.AdminUser = *adminUser
+		case "domain":
+			run.Domain = *domain
+		case "operation":
+			run.Operation = *operation
+		case "users":
+			run.NumberUsers = *numberUsers
+		case "secrets":
+			run.NumberSecrets = *numberSecrets
+		case "permissions":
+			run.NumberPermissions = *numberPermissions
+		case "secret-length":
+			run.SecretLength = *secretLength
+		case "cli-version":
+			run.CliVersion = *cliVersion
+		case "executor":
+			run.Executor = *executorType
+		case "load-duration":
+			run.LoadDuration = *loadDuration
+		case "load-rate":
+			run.LoadRate = *loadRate
+		case "virtual-users":
+			run.VirtualUsers = *virtualUsers
+		case "think-time":
+			run.ThinkTime = *thinkTime
+		case "think-jitter":
+			run.ThinkJitter = *thinkJitter
+		case "workload":
+			run.Workload = *workloadWeights
+		case "body-size":
+			run.BodySize = *bodySize
+		case "loop-targets":
+			run.LoopTargets = *loopTargets
+		case "random-targets":
+			run.RandomTargets = *randomTargets
+		case "selector":
+			run.Selector = *selector
+		case "redash":
+			run.Redash = *redash
+		case "record-results":
+			run.RecordResults = *recordResults
+		case "baseline":
+			run.Baseline = *baseline
+		case "save-baseline":
+			run.SaveBaseline = *saveBaseline
+		case "fail-on-regression":
+			run.FailOnRegression = *failOnRegression
+		case "latency-tolerance":
+			run.Tolerances.Latency = *latencyTolerance
+		case "success-tolerance":
+			run.Tolerances.Success = *successTolerance
+		case "throughput-tolerance":
+			run.Tolerances.Throughput = *throughputTolerance
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	flag "github.com/spf13/pflag"
+)
+
+const modelSecretKey = "model.json"
+
+var (
+	modelStoreType = flag.String("model-store", "file", "Where test models are kept between setup and test [file|secret]")
+	modelDir       = flag.String("model-dir", ".models", "Directory for test models when --model-store=file")
+	modelNamespace = flag.String("model-namespace", "default", "Namespace for test model secrets when --model-store=secret")
+
+	models modelStore
+
+	invalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+)
+
+// modelStore persists the loader model produced by setup so a tenant can be
+// tested any number of times after it has been populated
+type modelStore interface {
+	// Save stores the model for the tenant, replacing any previous one
+	Save(tenant string, model *postLoaderModel) error
+	// Load returns the model for the tenant, or nil if none was saved
+	Load(tenant string) (*postLoaderModel, error)
+	// Delete removes the model for the tenant if there is one
+	Delete(tenant string) error
+}
+
+func newModelStore(storeType string) (modelStore, error) {
+	switch storeType {
+	case "file":
+		return &fileModelStore{dir: *modelDir}, nil
+	case "secret":
+		return newSecretModelStore(*modelNamespace)
+	default:
+		return nil, fmt.Errorf("unknown model store '%s'", storeType)
+	}
+}
+
+// fileModelStore keeps one json file per tenant in a local directory
+type fileModelStore struct {
+	dir string
+}
+
+func (s *fileModelStore) path(tenant string) string {
+	return path.Join(s.dir, modelName(tenant)+".json")
+}
+
+func (s *fileModelStore) Save(tenant string, model *postLoaderModel) error {
+	asBytes, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	// write then rename so a concurrent Load never sees a partial model
+	tmp := s.path(tenant) + ".tmp"
+	if err := ioutil.WriteFile(tmp, asBytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(tenant))
+}
+
+func (s *fileModelStore) Load(tenant string) (*postLoaderModel, error) {
+	asBytes, err := ioutil.ReadFile(s.path(tenant))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var model postLoaderModel
+	if err := json.Unmarshal(asBytes, &model); err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+func (s *fileModelStore) Delete(tenant string) error {
+	if err := os.Remove(s.path(tenant)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// secretModelStore keeps each tenant's model in a kubernetes Secret since it
+// carries auth tokens. Models survive api pod restarts.
+type secretModelStore struct {
+	namespace string
+	clientset kubernetes.Interface
+}
+
+func newSecretModelStore(namespace string) (*secretModelStore, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		fmt.Printf("Error creating config: %v", err)
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		fmt.Printf("Error client: %v", err)
+		return nil, err
+	}
+	return &secretModelStore{
+		namespace: namespace,
+		clientset: clientset,
+	}, nil
+}
+
+func (s *secretModelStore) name(tenant string) string {
+	return "loadtest-model-" + modelName(tenant)
+}
+
+func (s *secretModelStore) Save(tenant string, model *postLoaderModel) error {
+	asBytes, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   s.name(tenant),
+			Labels: map[string]string{"app": "api"},
+		},
+		Data: map[string][]byte{
+			modelSecretKey: asBytes,
+		},
+	}
+	secrets := s.clientset.CoreV1().Secrets(s.namespace)
+	_, err = secrets.Create(secret)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(secret)
+	}
+	return err
+}
+
+func (s *secretModelStore) Load(tenant string) (*postLoaderModel, error) {
+	secret, err := s.clientset.CoreV1().Secrets(s.namespace).Get(s.name(tenant), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	asBytes, ok := secret.Data[modelSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no %s key", secret.Name, modelSecretKey)
+	}
+	var model postLoaderModel
+	if err := json.Unmarshal(asBytes, &model); err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+func (s *secretModelStore) Delete(tenant string) error {
+	err := s.clientset.CoreV1().Secrets(s.namespace).Delete(s.name(tenant), &metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// modelName turns a tenant into something safe for file and object names.
+// Names that had to change get a short hash of the original, so tenants like
+// Acme_1 and acme-1 don't share a model.
+func modelName(tenant string) string {
+	name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(tenant), "-"), "-")
+	if name == tenant {
+		return name
+	}
+	sum := sha256.Sum256([]byte(tenant))
+	return strings.TrimPrefix(name+"-"+hex.EncodeToString(sum[:4]), "-")
+}
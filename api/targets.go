@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/noahhai/kube-vegeta/formats"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+var (
+	targetsDir    = flag.String("targets-dir", ".targets", "Directory imported target files are kept in")
+	targetsFile   = flag.String("targets-file", "", "HAR or JSONL file of targets to replay instead of reading secrets")
+	targetsFormat = flag.String("targets-format", "", "Format of --targets-file [har|jsonl]. Guessed from the extension if not given")
+	loopTargets   = flag.Bool("loop-targets", false, "Start the targets over when they run out rather than ending the test")
+	randomTargets = flag.Bool("random-targets", false, "Pick targets at random rather than in order")
+)
+
+func targetsPath(id string) string {
+	return path.Join(*targetsDir, modelName(id)+".jsonl")
+}
+
+// saveTargets stores imported targets as JSONL under a new id
+func saveTargets(targets []vegeta.Target) (string, error) {
+	if len(targets) == 0 {
+		return "", fmt.Errorf("no targets to import")
+	}
+	if err := os.MkdirAll(*targetsDir, 0700); err != nil {
+		return "", err
+	}
+	id := newRunID()
+	tmp := targetsPath(id) + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for ix := range targets {
+		if err := encoder.Encode(&targets[ix]); err != nil {
+			file.Close()
+			return "", err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return "", err
+	}
+	file.Close()
+	return id, os.Rename(tmp, targetsPath(id))
+}
+
+func loadTargets(id string) ([]vegeta.Target, error) {
+	file, err := os.Open(targetsPath(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no targets with id %s", id)
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return formats.ParseJSONL(file)
+}
+
+// importTargetsFile parses and stores a local targets file, returning its id
+func importTargetsFile(file, format string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read targets %s: %v", file, err)
+	}
+	defer f.Close()
+	if format == "" {
+		format = formats.TargetsFormatOf(file)
+	}
+	targets, err := formats.ParseTargets(format, f)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("imported %d targets from %s\n", len(targets), file)
+	return saveTargets(targets)
+}
+
+// shardTargets deals the targets out to the loadbots in turn so every shard
+// samples the whole file. When there are fewer targets than loadbots every
+// loadbot replays all of them.
+func shardTargets(targets []vegeta.Target, numberLoadBots int) [][]vegeta.Target {
+	if numberLoadBots < 1 {
+		numberLoadBots = 1
+	}
+	shards := make([][]vegeta.Target, numberLoadBots)
+	if len(targets) < numberLoadBots {
+		for ix := range shards {
+			shards[ix] = targets
+		}
+		return shards
+	}
+	for ix := range targets {
+		shard := ix % numberLoadBots
+		shards[shard] = append(shards[shard], targets[ix])
+	}
+	return shards
+}
+
+// serveTargets handles POST /targets?format=har|jsonl, importing the body as
+// targets that runs can replay by id, and DELETE /targets/{id}
+func serveTargets(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/targets"), "/")
+	switch {
+	case r.Method == "POST" && id == "":
+		format := strings.ToLower(r.URL.Query().Get("format"))
+		if format == "" {
+			format = "jsonl"
+			if strings.Contains(r.Header.Get("Content-Type"), "har") {
+				format = "har"
+			}
+		}
+		targets, err := formats.ParseTargets(format, r.Body)
+		if err == nil {
+			id, err = saveTargets(targets)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Error: " + err.Error()))
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"ID": id, "Count": len(targets)})
+	case r.Method == "DELETE" && id != "":
+		if err := os.Remove(targetsPath(id)); err != nil && !os.IsNotExist(err) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Error: " + err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+	flag "github.com/spf13/pflag"
+)
+
+var templatesFile = flag.String("templates-file", "", "JSON or yaml file with a list of target templates loadbots generate requests from instead of reading secrets")
+
+// targetTemplate describes requests for loadbots to generate. Every field is
+// a go template over the tenant root, a random secret path, a token and the
+// hit's sequence number, with generators like uuid, randString and email.
+// Templates are picked in proportion to Weight, which defaults to 1. Results
+// are broken down by Name, which defaults to the method and url template.
+type targetTemplate struct {
+	Name   string            `json:"name"`
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Header map[string]string `json:"header"`
+	Body   string            `json:"body"`
+	Weight int               `json:"weight"`
+}
+
+// templateFuncs stands in for the loadbots' generators so templates can be
+// checked here before a test starts. Only the names matter.
+var templateFuncs = template.FuncMap{}
+
+func init() {
+	for _, name := range []string{"uuid", "randInt", "randString", "pick", "timestamp", "name", "username", "email", "company", "word", "sentence"} {
+		templateFuncs[name] = func(...interface{}) string { return "" }
+	}
+}
+
+// validate returns a description of each problem with the template
+func (t *targetTemplate) validate() []string {
+	problems := []string{}
+	if t.URL == "" {
+		problems = append(problems, "url is required")
+	}
+	if t.Weight < 0 {
+		problems = append(problems, "weight must not be negative")
+	}
+	fields := map[string]string{"method": t.Method, "url": t.URL, "body": t.Body}
+	for name, value := range t.Header {
+		fields["header."+name] = value
+	}
+	for name, text := range fields {
+		if _, err := template.New(name).Funcs(templateFuncs).Parse(text); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func validateTemplates(templates []targetTemplate) []string {
+	problems := []string{}
+	for ix := range templates {
+		for _, problem := range templates[ix].validate() {
+			problems = append(problems, fmt.Sprintf("template %d: %s", ix, problem))
+		}
+	}
+	return problems
+}
+
+func loadTemplatesFile(path string) ([]targetTemplate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var templates []targetTemplate
+	if err := yaml.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("failed to parse templates %s: %v", path, err)
+	}
+	return templates, nil
+}
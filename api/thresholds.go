@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// status of a run whose load test completed but broke one of its thresholds
+const statusThresholdsFailed = 2
+
+// thresholds are the assertions that decide whether a load test passed. They
+// are checked against the metrics of the whole fleet and unset ones are skipped.
+type thresholds struct {
+	// latency limits, as durations like 250ms or 2s
+	MaxP50     string `json:"maxP50,omitempty"`
+	MaxP95     string `json:"maxP95,omitempty"`
+	MaxP99     string `json:"maxP99,omitempty"`
+	MaxLatency string `json:"maxLatency,omitempty"`
+	// MinSuccess is the lowest acceptable ratio of successful requests, 0 to 1
+	MinSuccess float64 `json:"minSuccess,omitempty"`
+	// MaxErrors caps the number of responses per status code. Code 0 counts
+	// requests that failed without a response, e.g. timeouts.
+	MaxErrors map[string]int `json:"maxErrors,omitempty"`
+	// MinRate is the lowest acceptable achieved rate in requests per second
+	MinRate float64 `json:"minRate,omitempty"`
+}
+
+// violation describes a threshold the run did not meet
+type violation struct {
+	Threshold string `json:"threshold"`
+	Limit     string `json:"limit"`
+	Actual    string `json:"actual"`
+}
+
+func (t *thresholds) empty() bool {
+	return t == nil || (t.MaxP50 == "" && t.MaxP95 == "" && t.MaxP99 == "" && t.MaxLatency == "" &&
+		t.MinSuccess == 0 && len(t.MaxErrors) == 0 && t.MinRate == 0)
+}
+
+// latencyThresholds are the names of the latency thresholds in report order
+var latencyThresholds = []string{"maxP50", "maxP95", "maxP99", "maxLatency"}
+
+// latencies pairs each latency threshold with the name it is reported under
+func (t *thresholds) latencies() map[string]string {
+	return map[string]string{
+		"maxP50":     t.MaxP50,
+		"maxP95":     t.MaxP95,
+		"maxP99":     t.MaxP99,
+		"maxLatency": t.MaxLatency,
+	}
+}
+
+// validate returns a description of each problem with the thresholds
+func (t *thresholds) validate() []string {
+	problems := []string{}
+	if t == nil {
+		return problems
+	}
+	for _, name := range latencyThresholds {
+		limit := t.latencies()[name]
+		if limit == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(limit); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: '%s' is not a duration like 250ms or 2s", name, limit))
+		} else if d <= 0 {
+			problems = append(problems, fmt.Sprintf("%s: must be positive", name))
+		}
+	}
+	if t.MinSuccess < 0 || t.MinSuccess > 1 {
+		problems = append(problems, fmt.Sprintf("minSuccess: must be between 0 and 1, got %v", t.MinSuccess))
+	}
+	for code, max := range t.MaxErrors {
+		if _, err := strconv.Atoi(code); err != nil {
+			problems = append(problems, fmt.Sprintf("maxErrors: '%s' is not a status code", code))
+		}
+		if max < 0 {
+			problems = append(problems, fmt.Sprintf("maxErrors.%s: must not be negative", code))
+		}
+	}
+	if t.MinRate < 0 {
+		problems = append(problems, "minRate: must not be negative")
+	}
+	return problems
+}
+
+// evaluate checks the metrics against every threshold and returns those broken
+func (t *thresholds) evaluate(m *fleetMetrics) []violation {
+	violations := []violation{}
+	if t.empty() {
+		return violations
+	}
+	actuals := map[string]time.Duration{
+		"maxP50":     m.P50,
+		"maxP95":     m.P95,
+		"maxP99":     m.P99,
+		"maxLatency": m.Max,
+	}
+	for _, name := range latencyThresholds {
+		limit := t.latencies()[name]
+		if limit == "" {
+			continue
+		}
+		// already validated
+		d, _ := time.ParseDuration(limit)
+		if actuals[name] > d {
+			violations = append(violations, violation{
+				Threshold: name,
+				Limit:     d.String(),
+				Actual:    actuals[name].String(),
+			})
+		}
+	}
+	if t.MinSuccess > 0 && m.Success < t.MinSuccess {
+		violations = append(violations, violation{
+			Threshold: "minSuccess",
+			Limit:     strconv.FormatFloat(t.MinSuccess, 'f', -1, 64),
+			Actual:    strconv.FormatFloat(m.Success, 'f', 4, 64),
+		})
+	}
+	codes := []string{}
+	for code := range t.MaxErrors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		if actual := m.StatusCodes[code]; actual > t.MaxErrors[code] {
+			violations = append(violations, violation{
+				Threshold: "maxErrors." + code,
+				Limit:     strconv.Itoa(t.MaxErrors[code]),
+				Actual:    strconv.Itoa(actual),
+			})
+		}
+	}
+	if t.MinRate > 0 && m.Rate < t.MinRate {
+		violations = append(violations, violation{
+			Threshold: "minRate",
+			Limit:     strconv.FormatFloat(t.MinRate, 'f', -1, 64),
+			Actual:    strconv.FormatFloat(m.Rate, 'f', 2, 64),
+		})
+	}
+	return violations
+}
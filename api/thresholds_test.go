@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestThresholdsEvaluateBoundaries(t *testing.T) {
+	metrics := &fleetMetrics{
+		Rate:        50,
+		Success:     0.95,
+		P50:         100 * time.Millisecond,
+		P95:         400 * time.Millisecond,
+		P99:         900 * time.Millisecond,
+		Max:         2 * time.Second,
+		StatusCodes: map[string]int{"200": 95, "500": 4, "0": 1},
+	}
+	tests := []struct {
+		name       string
+		thresholds *thresholds
+		// the thresholds broken, in report order
+		broken []string
+	}{
+		{"none", nil, []string{}},
+		{"empty", &thresholds{}, []string{}},
+		{"p50 at limit", &thresholds{MaxP50: "100ms"}, []string{}},
+		{"p50 over limit", &thresholds{MaxP50: "99ms"}, []string{"maxP50"}},
+		{"p95 at limit", &thresholds{MaxP95: "400ms"}, []string{}},
+		{"p95 over limit", &thresholds{MaxP95: "399ms"}, []string{"maxP95"}},
+		{"p99 at limit", &thresholds{MaxP99: "900ms"}, []string{}},
+		{"p99 over limit", &thresholds{MaxP99: "899ms"}, []string{"maxP99"}},
+		{"max at limit", &thresholds{MaxLatency: "2s"}, []string{}},
+		{"max over limit", &thresholds{MaxLatency: "1.999s"}, []string{"maxLatency"}},
+		{"success at limit", &thresholds{MinSuccess: 0.95}, []string{}},
+		{"success under limit", &thresholds{MinSuccess: 0.951}, []string{"minSuccess"}},
+		{"errors at limit", &thresholds{MaxErrors: map[string]int{"500": 4}}, []string{}},
+		{"errors over limit", &thresholds{MaxErrors: map[string]int{"500": 3}}, []string{"maxErrors.500"}},
+		{"no response at limit", &thresholds{MaxErrors: map[string]int{"0": 1}}, []string{}},
+		{"no response over limit", &thresholds{MaxErrors: map[string]int{"0": 0}}, []string{"maxErrors.0"}},
+		{"unseen code", &thresholds{MaxErrors: map[string]int{"503": 0}}, []string{}},
+		{"rate at limit", &thresholds{MinRate: 50}, []string{}},
+		{"rate under limit", &thresholds{MinRate: 50.5}, []string{"minRate"}},
+		{
+			name: "every threshold broken",
+			thresholds: &thresholds{
+				MaxP50:     "50ms",
+				MaxP95:     "200ms",
+				MaxP99:     "500ms",
+				MaxLatency: "1s",
+				MinSuccess: 0.99,
+				MaxErrors:  map[string]int{"500": 0, "0": 0},
+				MinRate:    100,
+			},
+			broken: []string{"maxP50", "maxP95", "maxP99", "maxLatency", "minSuccess", "maxErrors.0", "maxErrors.500", "minRate"},
+		},
+	}
+	for _, test := range tests {
+		if problems := test.thresholds.validate(); len(problems) > 0 {
+			t.Fatalf("%s: invalid thresholds: %v", test.name, problems)
+		}
+		broken := []string{}
+		for _, v := range test.thresholds.evaluate(metrics) {
+			broken = append(broken, v.Threshold)
+		}
+		if fmt.Sprint(broken) != fmt.Sprint(test.broken) {
+			t.Errorf("%s: broke %v, want %v", test.name, broken, test.broken)
+		}
+	}
+}
+
+func TestThresholdsValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds *thresholds
+		problems   int
+	}{
+		{"none", nil, 0},
+		{"valid", &thresholds{MaxP99: "250ms", MinSuccess: 1, MaxErrors: map[string]int{"500": 0}, MinRate: 10}, 0},
+		{"not a duration", &thresholds{MaxP50: "fast"}, 1},
+		{"zero latency", &thresholds{MaxLatency: "0s"}, 1},
+		{"success above one", &thresholds{MinSuccess: 1.01}, 1},
+		{"negative success", &thresholds{MinSuccess: -0.1}, 1},
+		{"not a status code", &thresholds{MaxErrors: map[string]int{"5xx": 1}}, 1},
+		{"negative errors", &thresholds{MaxErrors: map[string]int{"500": -1}}, 1},
+		{"negative rate", &thresholds{MinRate: -1}, 1},
+	}
+	for _, test := range tests {
+		if problems := test.thresholds.validate(); len(problems) != test.problems {
+			t.Errorf("%s: got problems %v, want %d", test.name, problems, test.problems)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// tokenCredential is a user's access token along with what a loadbot needs to
+// refresh it before it expires
+type tokenCredential struct {
+	AccessToken  string
+	RefreshToken string
+	// ExpiresAt is zero when the token doesn't expire
+	ExpiresAt time.Time
+}
+
+// credential turns a token result into a credential, taking its expiry from now
+func (r *CmdResult) credential() tokenCredential {
+	c := tokenCredential{AccessToken: r.Value}
+	if r.Token != nil {
+		c.RefreshToken = r.Token.RefreshToken
+		if r.Token.ExpiresIn > 0 {
+			c.ExpiresAt = time.Now().Add(time.Duration(r.Token.ExpiresIn) * time.Second)
+		}
+	}
+	return c
+}
+
+func accessTokens(credentials []tokenCredential) []string {
+	tokens := make([]string, 0, len(credentials))
+	for _, c := range credentials {
+		tokens = append(tokens, c.AccessToken)
+	}
+	return tokens
+}
+
+// tokenURL is where loadbots exchange refresh tokens for the run's tenant
+func tokenURL(run *runConfig) string {
+	base := strings.NewReplacer("{tenant}", run.Tenant, "{domain}", run.Domain).Replace(*tenantURL)
+	return strings.TrimSuffix(base, "/") + *apiPrefix + "/token"
+}
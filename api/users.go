@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	virtualUsers = flag.Int("virtual-users", 0, "Number of virtual users across all loadbots. Each sends a request, waits for the response and thinks before the next, so the load reacts to latency. Replaces --load-rate when set")
+	thinkTime    = flag.Duration("think-time", time.Second, "How long a virtual user waits after a response before its next request")
+	thinkJitter  = flag.Duration("think-jitter", 0, "Up to how much longer than --think-time a virtual user waits, picked at random each time")
+)
+
+// splitUsers shares the virtual users between the loadbots as evenly as
+// possible. Callers make sure there are no more loadbots than users.
+func splitUsers(users, numberLoadBots int) []int {
+	if numberLoadBots < 1 {
+		numberLoadBots = 1
+	}
+	split := make([]int, numberLoadBots)
+	for ix := range split {
+		split[ix] = users / numberLoadBots
+		if ix < users%numberLoadBots {
+			split[ix]++
+		}
+	}
+	return split
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// workloadOps are the operations a loadbot can mix into its workload
+var workloadOps = []string{"read", "list", "update", "create", "delete", "permission"}
+
+var (
+	workloadWeights = flag.StringToInt("workload", map[string]int{}, "Weights of the operations to mix, e.g. read=80,update=10,create=5,delete=5. Operations are "+strings.Join(workloadOps, ", ")+". Defaults to reads only")
+	bodySize        = flag.Int("body-size", 0, "Length of the secret data loadbots generate for creates and updates. Defaults to --secret-length")
+)
+
+// validateWorkload returns a description of each problem with the weights
+func validateWorkload(weights map[string]int) []string {
+	problems := []string{}
+	total := 0
+	for op, weight := range weights {
+		known := false
+		for _, o := range workloadOps {
+			known = known || o == op
+		}
+		if !known {
+			problems = append(problems, fmt.Sprintf("unknown operation '%s'", op))
+		}
+		if weight < 0 {
+			problems = append(problems, fmt.Sprintf("weight of %s must not be negative", op))
+		}
+		total += weight
+	}
+	if len(weights) > 0 && total == 0 {
+		problems = append(problems, "no operation has a positive weight")
+	}
+	sort.Strings(problems)
+	return problems
+}
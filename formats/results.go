@@ -0,0 +1,22 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// NewResultsEncoder returns an encoder writing the format, one of bin, csv or json
+func NewResultsEncoder(format string, w io.Writer) (vegeta.Encoder, error) {
+	switch format {
+	case "", "bin":
+		return vegeta.NewEncoder(w), nil
+	case "csv":
+		return vegeta.NewCSVEncoder(w), nil
+	case "json":
+		return vegeta.NewJSONEncoder(w), nil
+	default:
+		return nil, fmt.Errorf("unknown results format '%s'", format)
+	}
+}
@@ -0,0 +1,107 @@
+// Package formats reads and writes the files the api and the loadbots share:
+// targets to replay and recorded results.
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// ParseTargets reads targets from a HAR export or a JSONL file of vegeta targets
+func ParseTargets(format string, r io.Reader) ([]vegeta.Target, error) {
+	switch format {
+	case "har":
+		return ParseHAR(r)
+	case "jsonl", "json":
+		return ParseJSONL(r)
+	default:
+		return nil, fmt.Errorf("unknown targets format '%s'. must be har or jsonl", format)
+	}
+}
+
+// TargetsFormatOf guesses the format of a targets file from its name
+func TargetsFormatOf(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), ".har") {
+		return "har"
+	}
+	return "jsonl"
+}
+
+// harLog is the part of a HAR export needed to replay its requests
+type harLog struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// ParseHAR reads the requests of a HAR export as targets
+func ParseHAR(r io.Reader) ([]vegeta.Target, error) {
+	var har harLog
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return nil, fmt.Errorf("invalid har: %v", err)
+	}
+	targets := make([]vegeta.Target, 0, len(har.Log.Entries))
+	for _, entry := range har.Log.Entries {
+		req := entry.Request
+		header := http.Header{}
+		for _, h := range req.Headers {
+			// http2 pseudo headers and lengths are set by the client
+			if strings.HasPrefix(h.Name, ":") || strings.EqualFold(h.Name, "Content-Length") {
+				continue
+			}
+			header.Add(h.Name, h.Value)
+		}
+		target := vegeta.Target{
+			Method: req.Method,
+			URL:    req.URL,
+			Header: header,
+		}
+		if req.PostData != nil {
+			target.Body = []byte(req.PostData.Text)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// ParseJSONL reads one vegeta target per line
+func ParseJSONL(r io.Reader) ([]vegeta.Target, error) {
+	targets := []vegeta.Target{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var target vegeta.Target
+		if err := json.Unmarshal([]byte(text), &target); err != nil {
+			return nil, fmt.Errorf("invalid target on line %d: %v", line, err)
+		}
+		if target.Method == "" || target.URL == "" {
+			return nil, fmt.Errorf("target on line %d needs a method and url", line)
+		}
+		targets = append(targets, target)
+	}
+	return targets, scanner.Err()
+}
@@ -0,0 +1,113 @@
+// Package histogram counts latencies in buckets that loadbots, the api and
+// the aggregator all agree on, so that histograms from different loadbots can
+// be merged and the fleet's percentiles read off the merged counts.
+package histogram
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// Latencies are bucketed by microsecond, exactly below Linear and with Sub
+// buckets per power of two above, so each bucket is at most 1/Sub of its
+// value wide.
+const (
+	Linear = 128
+	Sub    = 64
+)
+
+// Histogram counts latencies in log-linear buckets. Unlike the percentiles
+// vegeta reports, histograms of different loadbots can be merged.
+type Histogram struct {
+	// Counts are the number of latencies in each non empty bucket
+	Counts map[int]uint64 `json:"counts"`
+	Count  uint64         `json:"count"`
+	Max    time.Duration  `json:"max"`
+}
+
+// Bucket returns the bucket the latency is counted in
+func Bucket(latency time.Duration) int {
+	v := uint64(latency / time.Microsecond)
+	if v < Linear {
+		return int(v)
+	}
+	shift := uint(bits.Len64(v)) - 7
+	return Linear + int(shift-1)*Sub + int(v>>shift) - Sub
+}
+
+// Bounds returns the range of latencies counted in the bucket
+func Bounds(bucket int) (time.Duration, time.Duration) {
+	if bucket < Linear {
+		return time.Duration(bucket) * time.Microsecond, time.Duration(bucket+1) * time.Microsecond
+	}
+	k := bucket - Linear
+	shift := uint(k/Sub + 1)
+	top := uint64(k%Sub + Sub)
+	return time.Duration(top<<shift) * time.Microsecond, time.Duration((top+1)<<shift) * time.Microsecond
+}
+
+// Add counts a latency
+func (h *Histogram) Add(latency time.Duration) {
+	if h.Counts == nil {
+		h.Counts = map[int]uint64{}
+	}
+	h.Counts[Bucket(latency)]++
+	h.Count++
+	if latency > h.Max {
+		h.Max = latency
+	}
+}
+
+// Merge adds the counts of another histogram to this one
+func (h *Histogram) Merge(other *Histogram) {
+	if h.Counts == nil {
+		h.Counts = map[int]uint64{}
+	}
+	for bucket, n := range other.Counts {
+		h.Counts[bucket] += n
+	}
+	h.Count += other.Count
+	if other.Max > h.Max {
+		h.Max = other.Max
+	}
+}
+
+// Clone copies the histogram so it can be marshalled while the original
+// keeps counting
+func (h *Histogram) Clone() Histogram {
+	c := Histogram{Counts: make(map[int]uint64, len(h.Counts)), Count: h.Count, Max: h.Max}
+	for bucket, n := range h.Counts {
+		c.Counts[bucket] = n
+	}
+	return c
+}
+
+// Quantile estimates the latency below which q of the latencies fall by the
+// middle of its bucket
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	buckets := make([]int, 0, len(h.Counts))
+	for bucket := range h.Counts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+	var seen uint64
+	for _, bucket := range buckets {
+		if seen += h.Counts[bucket]; seen >= rank {
+			low, high := Bounds(bucket)
+			if mid := (low + high) / 2; mid < h.Max {
+				return mid
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
@@ -0,0 +1,71 @@
+package histogram
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBucketRoundTrip(t *testing.T) {
+	latencies := []time.Duration{0, time.Microsecond}
+	// either side of the switch from linear buckets and of every power of two
+	for us := time.Duration(Linear); us <= time.Hour/time.Microsecond; us *= 2 {
+		for _, d := range []time.Duration{us - 1, us, us + 1} {
+			latencies = append(latencies, d*time.Microsecond)
+		}
+	}
+	for _, latency := range latencies {
+		bucket := Bucket(latency)
+		low, high := Bounds(bucket)
+		if latency < low || latency >= high {
+			t.Errorf("%s counted in bucket %d of [%s, %s)", latency, bucket, low, high)
+		}
+		if latency >= Linear*time.Microsecond && float64(high-low) > float64(low)/Sub {
+			t.Errorf("bucket %d of [%s, %s) is wider than 1/%d of its value", bucket, low, high, Sub)
+		}
+	}
+	for bucket := 0; bucket < Linear+20*Sub; bucket++ {
+		_, high := Bounds(bucket)
+		if next, _ := Bounds(bucket + 1); high != next {
+			t.Fatalf("bucket %d ends at %s but bucket %d starts at %s", bucket, high, bucket+1, next)
+		}
+		if low, _ := Bounds(bucket); Bucket(low) != bucket {
+			t.Fatalf("bucket %d starts at %s, which is counted in bucket %d", bucket, low, Bucket(low))
+		}
+	}
+}
+
+func TestMergedQuantiles(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	// a fast loadbot and a slow one
+	var fast, slow Histogram
+	samples := []time.Duration{}
+	for i := 0; i < 9000; i++ {
+		latency := time.Duration(random.ExpFloat64() * float64(5*time.Millisecond))
+		fast.Add(latency)
+		samples = append(samples, latency)
+	}
+	for i := 0; i < 1000; i++ {
+		latency := 200*time.Millisecond + time.Duration(random.Int63n(int64(time.Second)))
+		slow.Add(latency)
+		samples = append(samples, latency)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	merged := Histogram{}
+	merged.Merge(&fast)
+	merged.Merge(&slow)
+	if merged.Count != uint64(len(samples)) || merged.Max != samples[len(samples)-1] {
+		t.Fatalf("merged %d latencies up to %s, want %d up to %s", merged.Count, merged.Max, len(samples), samples[len(samples)-1])
+	}
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99, 0.999, 1} {
+		want := samples[int(math.Ceil(q*float64(len(samples))))-1]
+		got := merged.Quantile(q)
+		// the estimate is the middle of the bucket the sample is in
+		if low, high := Bounds(Bucket(want)); got < low || got > high {
+			t.Errorf("p%v is %s, want %s within [%s, %s]", q*100, got, want, low, high)
+		}
+	}
+}
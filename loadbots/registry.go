@@ -0,0 +1,98 @@
+// Package loadbots keeps track of the loadbot pods for the api and the
+// aggregator.
+package loadbots
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Namespace is where the loadbots run. Callers reach them through the api
+// server's pod proxy in this namespace.
+const Namespace = "default"
+
+// Registry watches the loadbot pods matching a selector, so that looking up
+// the loadbots reads a local cache kept up to date as pods are added, become
+// ready, and are deleted, rather than listing pods each time
+type Registry struct {
+	clientset kubernetes.Interface
+	informer  cache.SharedIndexInformer
+	lister    listersv1.PodLister
+}
+
+// NewRegistry watches the pods in Namespace matching the selector once started
+func NewRegistry(clientset kubernetes.Interface, selector string) *Registry {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(Namespace), informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = selector
+	}))
+	pods := factory.Core().V1().Pods()
+	return &Registry{
+		clientset: clientset,
+		informer:  pods.Informer(),
+		lister:    pods.Lister(),
+	}
+}
+
+// Clientset is the client the registry watches the pods with
+func (r *Registry) Clientset() kubernetes.Interface {
+	return r.clientset
+}
+
+// Start watches the pods until stop is closed
+func (r *Registry) Start(stop <-chan struct{}) {
+	go r.informer.Run(stop)
+}
+
+// WaitForSync waits up to timeout for the first list of the pods
+func (r *Registry) WaitForSync(timeout time.Duration) error {
+	if r.informer.HasSynced() {
+		return nil
+	}
+	expired := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(expired) })
+	defer timer.Stop()
+	if !cache.WaitForCacheSync(expired, r.informer.HasSynced) {
+		return fmt.Errorf("timed out listing loadbots after %s", timeout)
+	}
+	return nil
+}
+
+// Loadbots returns the ready loadbots, sorted by name so work is spread over
+// them the same way each time
+func (r *Registry) Loadbots() ([]*corev1.Pod, error) {
+	pods, err := r.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	ready := []*corev1.Pod{}
+	for _, pod := range pods {
+		if PodReady(pod) {
+			ready = append(ready, pod)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].Name < ready[j].Name })
+	return ready, nil
+}
+
+// PodReady tells if the pod can take requests: it has an IP, is ready and
+// isn't being deleted
+func PodReady(pod *corev1.Pod) bool {
+	if pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package loadbots
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPod(name string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: Namespace, Labels: labels},
+		Status: corev1.PodStatus{
+			PodIP:      "10.0.0.1",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+		},
+	}
+}
+
+func markReady(pod *corev1.Pod) *corev1.Pod {
+	pod = pod.DeepCopy()
+	pod.Status.Conditions[0].Status = corev1.ConditionTrue
+	return pod
+}
+
+func markTerminating(pod *corev1.Pod) *corev1.Pod {
+	pod = pod.DeepCopy()
+	now := metav1.Now()
+	pod.DeletionTimestamp = &now
+	return pod
+}
+
+// waitForCache waits for the registry to see the pod in the state given, or
+// gone if state is nil
+func waitForCache(t *testing.T, r *Registry, name string, state func(*corev1.Pod) bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		pod, err := r.lister.Pods(Namespace).Get(name)
+		if state == nil && err != nil || state != nil && err == nil && state(pod) {
+			return
+		}
+	}
+	t.Fatalf("registry never saw the change to pod %s", name)
+}
+
+// expectLoadbots waits for the registry to catch up with the watched changes
+func expectLoadbots(t *testing.T, r *Registry, want ...string) {
+	t.Helper()
+	var names []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		ready, err := r.Loadbots()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = []string{}
+		for _, pod := range ready {
+			names = append(names, pod.Name)
+		}
+		if len(want) == 0 && len(names) == 0 || reflect.DeepEqual(names, want) {
+			return
+		}
+	}
+	t.Fatalf("loadbots are %v, want %v", names, want)
+}
+
+func TestRegistryFollowsPods(t *testing.T) {
+	loadbot := map[string]string{"run": "vegeta"}
+	ready := markReady(newPod("loadbot-b", loadbot))
+	elsewhere := markReady(newPod("loadbot-elsewhere", loadbot))
+	elsewhere.Namespace = "other"
+	clientset := fake.NewSimpleClientset(ready, elsewhere, markReady(newPod("nginx", map[string]string{"run": "nginx"})))
+	pods := clientset.CoreV1().Pods(Namespace)
+
+	r := NewRegistry(clientset, "run=vegeta")
+	stop := make(chan struct{})
+	defer close(stop)
+	r.Start(stop)
+	if err := r.WaitForSync(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expectLoadbots(t, r, "loadbot-b")
+
+	added := newPod("loadbot-a", loadbot)
+	if _, err := pods.Create(added); err != nil {
+		t.Fatal(err)
+	}
+	waitForCache(t, r, added.Name, func(*corev1.Pod) bool { return true })
+	expectLoadbots(t, r, "loadbot-b")
+
+	added = markReady(added)
+	if _, err := pods.Update(added); err != nil {
+		t.Fatal(err)
+	}
+	expectLoadbots(t, r, "loadbot-a", "loadbot-b")
+
+	if _, err := pods.Update(markTerminating(added)); err != nil {
+		t.Fatal(err)
+	}
+	waitForCache(t, r, added.Name, func(pod *corev1.Pod) bool { return pod.DeletionTimestamp != nil })
+	expectLoadbots(t, r, "loadbot-b")
+
+	if err := pods.Delete(added.Name, &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForCache(t, r, added.Name, nil)
+	expectLoadbots(t, r, "loadbot-b")
+
+	if err := pods.Delete(ready.Name, &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectLoadbots(t, r)
+}
+
+func TestPodReady(t *testing.T) {
+	pod := newPod("loadbot", nil)
+	if PodReady(pod) {
+		t.Error("pod that isn't ready is ready")
+	}
+	if !PodReady(markReady(pod)) {
+		t.Error("ready pod isn't ready")
+	}
+	if PodReady(markTerminating(markReady(pod))) {
+		t.Error("terminating pod is ready")
+	}
+	noIP := markReady(pod)
+	noIP.Status.PodIP = ""
+	if PodReady(noIP) {
+		t.Error("pod without an IP is ready")
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/NebulousLabs/fastrand"
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"github.com/jhump/protoreflect/grpcreflect"
+	flag "github.com/spf13/pflag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpccreds "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+var (
+	grpcTarget      = flag.String("grpc-target", "", "host:port of a gRPC server to call instead of the tenant's http api")
+	grpcMethod      = flag.String("grpc-method", "", "Unary method to call, e.g. pb.GCDService/Compute")
+	grpcRequest     = flag.String("grpc-request", "{}", "Template of the JSON request message, e.g. {\"a\": {{randInt 1 1000}}}")
+	grpcProtos      = flag.StringSlice("grpc-proto", []string{}, "Proto files describing the method. The server's reflection service is used if none are given")
+	grpcPlaintext   = flag.Bool("grpc-plaintext", false, "Connect to the gRPC server without TLS")
+	grpcInsecure    = flag.Bool("grpc-insecure", false, "Skip verifying the gRPC server's certificate")
+	grpcConnections = flag.Int("grpc-connections", 1, "Number of connections to spread gRPC calls over")
+	grpcMaxWorkers  = flag.Int("grpc-max-workers", 1000, "Most calls in flight at once. Calls wait for a free worker beyond this, so a slow server can't pile them up")
+)
+
+// grpcConfig describes a gRPC attack. Request and the values of Metadata are
+// templates over the same variables and generators as target templates.
+type grpcConfig struct {
+	Target   string
+	Method   string
+	Request  string
+	Metadata map[string]string
+	// Protos are the sources of the proto files describing the method, by
+	// file name. Without them the server's reflection service is asked.
+	Protos    map[string]string
+	Plaintext bool
+	// Insecure skips verifying the server's certificate
+	Insecure    bool
+	Connections int
+	// MaxWorkers caps the calls in flight, --grpc-max-workers if unset
+	MaxWorkers int
+}
+
+func (c *grpcConfig) validate() error {
+	if c.Target == "" {
+		return errors.New("grpc target is required")
+	}
+	if c.Method == "" {
+		return errors.New("grpc method is required")
+	}
+	if c.Connections < 0 {
+		return errors.New("grpc connections must not be negative")
+	}
+	if c.MaxWorkers < 0 {
+		return errors.New("grpc max workers must not be negative")
+	}
+	return nil
+}
+
+// grpcConfigFromFlags builds the attack's config from the command line
+func grpcConfigFromFlags() (*grpcConfig, error) {
+	c := &grpcConfig{
+		Target:      *grpcTarget,
+		Method:      *grpcMethod,
+		Request:     *grpcRequest,
+		Plaintext:   *grpcPlaintext,
+		Insecure:    *grpcInsecure,
+		Connections: *grpcConnections,
+		MaxWorkers:  *grpcMaxWorkers,
+		Protos:      map[string]string{},
+	}
+	for _, file := range *grpcProtos {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		// imports are resolved by name relative to the proto's directory
+		c.Protos[filepath.Base(file)] = string(data)
+	}
+	return c, c.validate()
+}
+
+// grpcCall calls one unary method with requests rendered from a template
+type grpcCall struct {
+	conns    []*grpc.ClientConn
+	stubs    []grpcdynamic.Stub
+	method   *desc.MethodDescriptor
+	request  *template.Template
+	metadata map[string]*template.Template
+	route    string
+	next     uint64
+	// maxWorkers caps the workers calling the method
+	maxWorkers int
+}
+
+// newGRPCCall connects to the target and resolves the method
+func newGRPCCall(c *grpcConfig) (*grpcCall, error) {
+	call := &grpcCall{
+		metadata:   map[string]*template.Template{},
+		route:      "grpc " + c.Method,
+		maxWorkers: c.MaxWorkers,
+	}
+	if call.maxWorkers < 1 {
+		// requests from the api leave it to the loadbot
+		call.maxWorkers = *grpcMaxWorkers
+	}
+	var err error
+	if call.request, err = template.New("request").Funcs(templateFuncs).Parse(c.Request); err != nil {
+		return nil, fmt.Errorf("invalid grpc request: %v", err)
+	}
+	for key, value := range c.Metadata {
+		if call.metadata[key], err = template.New("metadata." + key).Funcs(templateFuncs).Parse(value); err != nil {
+			return nil, fmt.Errorf("invalid grpc metadata: %v", err)
+		}
+	}
+
+	creds := grpc.WithInsecure()
+	if !c.Plaintext {
+		if c.Insecure {
+			log.Printf("not verifying the certificate of %s\n", c.Target)
+		}
+		creds = grpc.WithTransportCredentials(grpccreds.NewTLS(&tls.Config{InsecureSkipVerify: c.Insecure}))
+	}
+	connections := c.Connections
+	if connections < 1 {
+		connections = 1
+	}
+	for i := 0; i < connections; i++ {
+		conn, err := grpc.Dial(c.Target, creds)
+		if err != nil {
+			call.close()
+			return nil, fmt.Errorf("failed to connect to %s: %v", c.Target, err)
+		}
+		call.conns = append(call.conns, conn)
+		call.stubs = append(call.stubs, grpcdynamic.NewStub(conn))
+	}
+
+	if call.method, err = resolveMethod(call.conns[0], c.Method, c.Protos); err != nil {
+		call.close()
+		return nil, err
+	}
+	if call.method.IsClientStreaming() || call.method.IsServerStreaming() {
+		call.close()
+		return nil, fmt.Errorf("%s is a streaming method. only unary methods can be called", c.Method)
+	}
+	// a request that fails to render would fail every call, so check one
+	if _, err := call.message(&templateData{}); err != nil {
+		call.close()
+		return nil, fmt.Errorf("invalid grpc request: %v", err)
+	}
+	return call, nil
+}
+
+// resolveMethod finds a method named like pkg.Service/Method in the protos,
+// or through the server's reflection service if there are none
+func resolveMethod(conn *grpc.ClientConn, name string, protos map[string]string) (*desc.MethodDescriptor, error) {
+	ix := strings.LastIndexAny(name, "/.")
+	if ix <= 0 || ix == len(name)-1 {
+		return nil, fmt.Errorf("grpc method '%s' must look like package.Service/Method", name)
+	}
+	serviceName, methodName := strings.TrimPrefix(name[:ix], "/"), name[ix+1:]
+
+	var service *desc.ServiceDescriptor
+	if len(protos) > 0 {
+		names := []string{}
+		for file := range protos {
+			names = append(names, file)
+		}
+		parser := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(protos)}
+		files, err := parser.ParseFiles(names...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse protos: %v", err)
+		}
+		for _, file := range files {
+			if service = file.FindService(serviceName); service != nil {
+				break
+			}
+		}
+		if service == nil {
+			return nil, fmt.Errorf("no service %s in the protos", serviceName)
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), vegeta.DefaultTimeout)
+		defer cancel()
+		client := grpcreflect.NewClient(ctx, rpb.NewServerReflectionClient(conn))
+		defer client.Reset()
+		var err error
+		if service, err = client.ResolveService(serviceName); err != nil {
+			return nil, fmt.Errorf("failed to resolve %s through reflection: %v", serviceName, err)
+		}
+	}
+	method := service.FindMethodByName(methodName)
+	if method == nil {
+		return nil, fmt.Errorf("service %s has no method %s", serviceName, methodName)
+	}
+	return method, nil
+}
+
+func (c *grpcCall) close() {
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+func (c *grpcCall) message(data *templateData) (*dynamic.Message, error) {
+	body, err := render(c.request, data)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamic.NewMessage(c.method.GetInputType())
+	if err := msg.UnmarshalJSON([]byte(body)); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// hit makes one call and reports it the way vegeta reports an http request,
+// with the status code an http gateway would give for the gRPC code
+func (c *grpcCall) hit(data *templateData) *vegeta.Result {
+	res := &vegeta.Result{Attack: "main", Seq: data.Seq, Timestamp: time.Now()}
+	defer func() {
+		res.Latency = time.Since(res.Timestamp)
+	}()
+	msg, err := c.message(data)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	md := metadata.MD{}
+	for key, t := range c.metadata {
+		value, err := render(t, data)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		md.Set(key, value)
+	}
+	res.BytesOut = uint64(proto.Size(msg))
+
+	// calls aren't cancelled with the attack, just as vegeta lets in flight
+	// requests finish
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), vegeta.DefaultTimeout)
+	defer cancel()
+	stub := c.stubs[atomic.AddUint64(&c.next, 1)%uint64(len(c.stubs))]
+	resp, err := stub.InvokeRpc(ctx, c.method, msg)
+	st := status.Convert(err)
+	res.Code = httpStatusOf(st.Code())
+	if err != nil {
+		res.Error = fmt.Sprintf("%s: %s", st.Code(), st.Message())
+		return res
+	}
+	res.BytesIn = uint64(proto.Size(resp))
+	return res
+}
+
+// httpStatusOf maps gRPC codes to http statuses like grpc-gateway does, so
+// success rates and status codes read the same as for http attacks
+func httpStatusOf(code codes.Code) uint16 {
+	switch code {
+	case codes.OK:
+		return 200
+	case codes.Canceled:
+		return 408
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return 400
+	case codes.DeadlineExceeded:
+		return 504
+	case codes.NotFound:
+		return 404
+	case codes.AlreadyExists, codes.Aborted:
+		return 409
+	case codes.PermissionDenied:
+		return 403
+	case codes.Unauthenticated:
+		return 401
+	case codes.ResourceExhausted:
+		return 429
+	case codes.Unimplemented:
+		return 501
+	case codes.Unavailable:
+		return 503
+	default:
+		return 500
+	}
+}
+
+// attackGRPC calls the method at the pace given until du elapses or ctx is
+// done. Like vegeta's attacker, it starts more workers when they are all
+// busy so slow calls don't hold back the rate, up to the call's maxWorkers.
+func attackGRPC(ctx context.Context, call *grpcCall, pacer vegeta.Pacer, du time.Duration, workers int, base templateData, paths []string, tokens *tokenPool, routes *routeRecorder) <-chan *vegeta.Result {
+	results := make(chan *vegeta.Result)
+	ticks := make(chan struct{})
+	wg := sync.WaitGroup{}
+	var seq uint64
+	worker := func() {
+		defer wg.Done()
+		for range ticks {
+			data := base
+			data.Seq = atomic.AddUint64(&seq, 1) - 1
+			if len(paths) > 0 {
+				data.Path = strings.TrimPrefix(paths[fastrand.Intn(len(paths))], "/")
+			}
+			if current := tokens.get(); len(current) > 0 {
+				data.Token = current[fastrand.Intn(len(current))]
+			}
+			res := call.hit(&data)
+			routes.add(call.route, res)
+			results <- res
+		}
+	}
+	if workers > call.maxWorkers {
+		workers = call.maxWorkers
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go worker()
+	}
+
+	go func() {
+		defer close(results)
+		defer wg.Wait()
+		defer close(ticks)
+		began, count := time.Now(), uint64(0)
+		for {
+			elapsed := time.Since(began)
+			if du > 0 && elapsed > du {
+				return
+			}
+			wait, stop := pacer.Pace(elapsed, count)
+			if stop {
+				return
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case ticks <- struct{}{}:
+				count++
+				continue
+			case <-ctx.Done():
+				return
+			default:
+				// every worker is busy. past the cap the tick waits for one
+				if workers < call.maxWorkers {
+					workers++
+					wg.Add(1)
+					go worker()
+				}
+			}
+			select {
+			case ticks <- struct{}{}:
+				count++
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return results
+}
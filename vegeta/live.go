@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/noahhai/kube-vegeta/histogram"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// how many seconds of results are kept for the rolling windows
+const liveWindowSeconds = 10
+
+// liveAttack keeps rolling metrics for an attack while it runs so progress can
+// be polled long before the attack answers its /command request
+type liveAttack struct {
+	sync.Mutex
+	runID      string
+	began      time.Time
+	done       bool
+	cumulative vegeta.Metrics
+	histogram  histogram.Histogram
+	// buckets holds one second of results each, newest last. Bodies are
+	// dropped to keep the memory bounded at high rates.
+	buckets     [][]vegeta.Result
+	bucketStart time.Time
+	exporter    *runExporter
+}
+
+// liveSnapshot is what /live reports for an attack
+type liveSnapshot struct {
+	RunID      string
+	Began      time.Time
+	Elapsed    time.Duration
+	Done       bool
+	Last1s     *windowMetrics
+	Last10s    *windowMetrics
+	Cumulative *windowMetrics
+}
+
+// windowMetrics are the metrics of a window with a histogram of its latencies
+// that can be merged with other loadbots'
+type windowMetrics struct {
+	vegeta.Metrics
+	LatencyHistogram histogram.Histogram `json:"latencyHistogram"`
+}
+
+func newLiveAttack(runID string, began time.Time) *liveAttack {
+	return &liveAttack{
+		runID:       runID,
+		began:       began,
+		bucketStart: began.Truncate(time.Second),
+		buckets:     [][]vegeta.Result{{}},
+		exporter:    newRunExporter(runID),
+	}
+}
+
+func (l *liveAttack) Add(res *vegeta.Result) {
+	l.Lock()
+	defer l.Unlock()
+	l.cumulative.Add(res)
+	l.histogram.Add(res.Latency)
+	l.exporter.add(res)
+	l.rotate(time.Now())
+	stripped := *res
+	stripped.Body = nil
+	last := len(l.buckets) - 1
+	l.buckets[last] = append(l.buckets[last], stripped)
+}
+
+// rotate starts a new bucket for every second that has passed. Caller holds the lock.
+func (l *liveAttack) rotate(now time.Time) {
+	for now.Sub(l.bucketStart) >= time.Second {
+		l.buckets = append(l.buckets, []vegeta.Result{})
+		l.bucketStart = l.bucketStart.Add(time.Second)
+		if len(l.buckets) > liveWindowSeconds+1 {
+			l.buckets = l.buckets[1:]
+		}
+	}
+}
+
+func (l *liveAttack) finish() {
+	l.Lock()
+	defer l.Unlock()
+	l.done = true
+}
+
+// window builds metrics from the results of the last n complete seconds
+func (l *liveAttack) window(n int) *windowMetrics {
+	// the newest bucket is still filling so it is left out
+	complete := l.buckets[:len(l.buckets)-1]
+	if l.done {
+		complete = l.buckets
+	}
+	if len(complete) > n {
+		complete = complete[len(complete)-n:]
+	}
+	m := &windowMetrics{}
+	for _, bucket := range complete {
+		for ix := range bucket {
+			m.Add(&bucket[ix])
+			m.LatencyHistogram.Add(bucket[ix].Latency)
+		}
+	}
+	closeMetrics(&m.Metrics)
+	return m
+}
+
+func (l *liveAttack) snapshot() *liveSnapshot {
+	l.Lock()
+	defer l.Unlock()
+	if !l.done {
+		l.rotate(time.Now())
+	}
+	// the copy is marshalled after the lock is released while the attack
+	// keeps adding to the original, so it must not share the maps
+	cumulative := windowMetrics{Metrics: *closeMetrics(&l.cumulative), LatencyHistogram: l.histogram.Clone()}
+	cumulative.StatusCodes = map[string]int{}
+	for code, n := range l.cumulative.StatusCodes {
+		cumulative.StatusCodes[code] = n
+	}
+	cumulative.Errors = append([]string{}, l.cumulative.Errors...)
+	cumulative.Histogram = nil
+	return &liveSnapshot{
+		RunID:      l.runID,
+		Began:      l.began,
+		Elapsed:    time.Since(l.began),
+		Done:       l.done,
+		Last1s:     l.window(1),
+		Last10s:    l.window(liveWindowSeconds),
+		Cumulative: &cumulative,
+	}
+}
+
+// closeMetrics computes the summary of metrics that may have no results yet.
+// Closing empty metrics would divide by zero.
+func closeMetrics(m *vegeta.Metrics) *vegeta.Metrics {
+	if m.Requests > 0 {
+		m.Close()
+	}
+	return m
+}
+
+// liveSet tracks the attacks running on this loadbot and the last to finish
+type liveSet struct {
+	sync.Mutex
+	running map[string]*liveAttack
+	last    *liveAttack
+}
+
+var live = &liveSet{running: map[string]*liveAttack{}}
+
+func (s *liveSet) start(runID string) *liveAttack {
+	s.Lock()
+	defer s.Unlock()
+	l := newLiveAttack(runID, time.Now())
+	s.running[runID] = l
+	return l
+}
+
+func (s *liveSet) finish(l *liveAttack) {
+	l.finish()
+	s.Lock()
+	defer s.Unlock()
+	if s.running[l.runID] == l {
+		delete(s.running, l.runID)
+	}
+	// the previous run is no longer served, so neither are its series
+	if old := s.last; old != nil && old.runID != l.runID {
+		if _, running := s.running[old.runID]; !running {
+			old.exporter.forget()
+		}
+	}
+	s.last = l
+}
+
+// get returns the running attack for the run, or the last finished one if it
+// was for the same run. An empty run matches any attack.
+func (s *liveSet) get(runID string) *liveAttack {
+	s.Lock()
+	defer s.Unlock()
+	if l, ok := s.running[runID]; ok {
+		return l
+	}
+	if runID == "" {
+		var newest *liveAttack
+		for _, l := range s.running {
+			if newest == nil || l.began.After(newest.began) {
+				newest = l
+			}
+		}
+		if newest != nil {
+			return newest
+		}
+	}
+	if s.last != nil && (runID == "" || s.last.runID == runID) {
+		return s.last
+	}
+	return nil
+}
+
+// serveLive handles GET /live?run= with the rolling metrics of the run's attack,
+// or of the newest attack if no run is given
+func serveLive(w http.ResponseWriter, r *http.Request) {
+	l := live.get(r.URL.Query().Get("run"))
+	if l == nil {
+		logAndReturnFail(w, "no attack for run '"+r.URL.Query().Get("run")+"'", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, l.snapshot())
+}
+
+// serveCurrent handles GET / with the cumulative metrics of the newest attack
+// in the same shape as the HTTPReporter plus the latency histogram, for the
+// aggregator and dashboard
+func serveCurrent(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	metrics := &windowMetrics{}
+	if l := live.get(""); l != nil {
+		metrics = l.snapshot().Cumulative
+	}
+	writeJSON(w, metrics)
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	asBytes, err := json.Marshal(body)
+	if err != nil {
+		logAndReturnFail(w, "error marshalling response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(asBytes)
+}
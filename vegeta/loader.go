@@ -1,22 +1,28 @@
-// TODO : make this concurrency safe when run in serve mode; spin a context off of the global flags
 package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/NebulousLabs/fastrand"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	flag "github.com/spf13/pflag"
 
+	"github.com/noahhai/kube-vegeta/histogram"
+
 	vegeta "github.com/tsenart/vegeta/lib"
 )
 
@@ -26,14 +32,15 @@ var (
 	reportPort        = flag.Int("report-port", 3001, "Port to run reporting on if --serve NOT specified")
 	tenant            = flag.String("tenant", "", "Tenant name to create")
 	domain            = flag.String("domain", "qabambe.com", "Tenant domain. Default is qabambe.com")
-	secretPaths       []string
 	secretPathsString = flag.String("secret-paths", "", "A comma separated list of secret paths to test")
-	tokens            []string
+	templatesFile     = flag.String("templates-file", "", "JSON file with a list of target templates to generate requests from")
 	tokensString      = flag.String("tokens", "", "A comma separated list of valid auth tokens")
 	rate              = flag.Int("rate", 1, "The QPS to send")
 	duration          = flag.Duration("duration", 10*time.Second, "The duration of the load test")
 	workers           = flag.Int("workers", 10, "The number of workers to use")
 	staticTargeter    = flag.Bool("static-targeter", false, "Use static targeter rather than dynamic targeter")
+	workloadWeights   = flag.StringToInt("workload", map[string]int{opRead: 1}, "Weights of the operations to mix, e.g. read=80,update=10,create=5,delete=5. Operations are read, list, update, create, delete and permission")
+	bodySize          = flag.Int("body-size", 100, "Length of the secret data generated for creates and updates")
 )
 
 // HTTPReporter outputs metrics over HTTP
@@ -66,10 +73,15 @@ func (h *HTTPReporter) SetMetrics(metrics *vegeta.Metrics) {
 
 func main() {
 	flag.Parse()
-	validateCmd()
+	cmd := validateCmd()
 
 	if *serve {
 		http.HandleFunc("/command", serveFunc)
+		http.HandleFunc("/cancel", serveCancel)
+		http.HandleFunc("/live", serveLive)
+		http.HandleFunc("/results", serveResults)
+		http.HandleFunc("/", serveCurrent)
+		http.Handle("/metrics", promhttp.Handler())
 		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
 	} else {
 		reporter := &HTTPReporter{}
@@ -77,35 +89,130 @@ func main() {
 			log.Println()
 			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *reportPort), reporter))
 		}()
-		metrics := doAttack()
-		reporter.SetMetrics(metrics)
+		metrics := doAttack(context.Background(), cmd)
+		reporter.SetMetrics(&metrics.Metrics)
 		log.Println("press any key to stop serving results and quit")
 		reader := bufio.NewReader(os.Stdin)
 		reader.ReadString('\n')
 	}
 }
 
-func doAttack() *vegeta.Metrics {
+// attackMetrics are the metrics of an attack, broken down by phase when the
+// attack followed a load profile, and by the route of each request
+type attackMetrics struct {
+	vegeta.Metrics
+	LatencyHistogram histogram.Histogram `json:"latencyHistogram"`
+	Phases           []phaseMetrics      `json:"phases,omitempty"`
+	Routes           []routeMetrics      `json:"routes,omitempty"`
+}
+
+// attackConfig is everything one attack needs. Each request to /command
+// gets its own so attacks for different runs can share a loadbot.
+type attackConfig struct {
+	runID          string
+	tenant         string
+	domain         string
+	rate           int
+	duration       time.Duration
+	workers        int
+	staticTargeter bool
+	recordResults  bool
+	workload       map[string]int
+	bodySize       int
+	secretPaths    []string
+	tokens         []string
+	credentials    []tokenCredential
+	tokenURL       string
+	phases         []loadPhase
+	targets        []vegeta.Target
+	loopTargets    bool
+	randomTargets  bool
+	templates      []targetTemplate
+	users          int
+	thinkTime      time.Duration
+	thinkJitter    time.Duration
+	// rpc is the resolved method of a gRPC attack, set up before the attack
+	// so that a bad method or template fails the request
+	rpc *grpcCall
+}
+
+// defaultAttackConfig is an attack with the loadbot's flags, which requests
+// fall back on for whatever they leave out
+func defaultAttackConfig() *attackConfig {
+	c := &attackConfig{
+		tenant:         *tenant,
+		domain:         *domain,
+		rate:           *rate,
+		duration:       *duration,
+		workers:        *workers,
+		staticTargeter: *staticTargeter,
+		workload:       map[string]int{opRead: 1},
+		bodySize:       *bodySize,
+		secretPaths:    strings.Split(*secretPathsString, ","),
+		thinkTime:      *thinkTime,
+		thinkJitter:    *thinkJitter,
+	}
+	if *tokensString != "" {
+		c.tokens = strings.Split(*tokensString, ",")
+	}
+	return c
+}
+
+// configFromFlags is the attack the command line asks for
+func configFromFlags() *attackConfig {
+	c := defaultAttackConfig()
+	c.recordResults = *recordResults
+	c.workload = *workloadWeights
+	c.loopTargets = *loopTargets
+	c.randomTargets = *randomTargets
+	c.users = *users
+	return c
+}
+
+// baseData is what every template rendered during the attack shares
+func (c *attackConfig) baseData(root string) templateData {
+	return templateData{
+		Root:   strings.TrimSuffix(root, "/"),
+		Tenant: c.tenant,
+		Domain: c.domain,
+	}
+}
+
+// doAttack runs the attack until its duration elapses or ctx is cancelled,
+// in which case the metrics collected so far are returned. Progress can be
+// followed on /live under the run ID while it runs.
+func doAttack(ctx context.Context, c *attackConfig) *attackMetrics {
 	fmt.Println("preparing targeting")
-	requestBase := fmt.Sprintf("https://%s.%s/", *tenant, *domain)
+	runID := c.runID
+	requestBase := fmt.Sprintf("https://%s.%s/", c.tenant, c.domain)
 	var targets []vegeta.Target
-	if len(secretPaths) < 1 {
-		secretPaths = strings.Split(*secretPathsString, ",")
-	}
+	pool := newTokenPool(c.tokens)
+	refreshCtx, stopRefresh := context.WithCancel(ctx)
+	defer stopRefresh()
+	refreshTokens(refreshCtx, pool, c.tokenURL, c.credentials)
 
-	// TODO : test perf between static and json attacker
-	// tradeoff is that if we use static, we have to pre-select auth-path pairs
-	// but with JSON targeter, we can use a generator for a new random pair each time
+	// the static targeter pre-selects auth-path pairs, the generator picks a
+	// new random pair each time
 	var targeter vegeta.Targeter
-	if !*staticTargeter {
-		targetReader := NewTargetReader(requestBase, secretPaths, tokens)
-		targeter = vegeta.NewJSONTargeter(targetReader, nil, nil)
+	var generator *targetGenerator
+	if len(c.targets) > 0 {
+		log.Printf("replaying %d targets (loop: %v, random: %v)\n", len(c.targets), c.loopTargets, c.randomTargets)
+		targeter = newReplayTargeter(c.targets, c.loopTargets, c.randomTargets)
+	} else if len(c.templates) > 0 {
+		log.Printf("generating targets from %d templates\n", len(c.templates))
+		// already validated
+		targeter, _ = newTemplateTargeter(c.templates, c.baseData(requestBase), c.secretPaths, pool)
+	} else if !c.staticTargeter {
+		// already validated
+		mix, _ := newWorkload(c.workload)
+		generator = newTargetGenerator(requestBase, c.secretPaths, pool, mix, c.bodySize)
+		targeter = generator.Targeter()
 	} else {
-		for _, path := range secretPaths {
+		for _, path := range c.secretPaths {
 			path = strings.TrimPrefix(path, "/")
 			targets = append(targets, vegeta.Target{
 				Method: "GET",
-				URL:    fmt.Sprintf("%s/%s", requestBase, path),
+				URL:    withRoute(fmt.Sprintf("%s/%s", requestBase, path), opRead+" "+pathTemplate(path)),
 			})
 			//fmt.Printf(fmt.Sprintf("adding target:%s/%s\n", requestBase, path))
 		}
@@ -113,20 +220,83 @@ func doAttack() *vegeta.Metrics {
 	}
 
 	log.Println("starting attack session")
-	attacker := vegeta.NewAttacker(vegeta.Workers(uint64(*workers)))
-	attackRate := vegeta.Rate{
-		Freq: *rate,
+	client, routes := newRouteClient()
+	if generator != nil {
+		routes.observe = generator.observe
+	}
+	attacker := vegeta.NewAttacker(vegeta.Client(client), vegeta.Workers(uint64(c.workers)))
+	var pacer vegeta.Pacer = vegeta.Rate{
+		Freq: c.rate,
 		Per:  time.Second,
 	}
-	metrics := &vegeta.Metrics{}
-	for res := range attacker.Attack(targeter, attackRate, *duration, "main") {
+	attackDuration := c.duration
+	if len(c.phases) > 0 {
+		phased := newPhasePacer(c.phases)
+		pacer = phased
+		attackDuration = phased.total
+		log.Printf("following %d phase load profile over %s\n", len(c.phases), attackDuration)
+	}
+	if c.users > 0 {
+		// each user's attack has a single worker so it waits on its response
+		attacker = vegeta.NewAttacker(vegeta.Client(client), vegeta.Workers(1), vegeta.MaxWorkers(1))
+		log.Printf("running %d virtual users thinking %s (+%s)\n", c.users, c.thinkTime, c.thinkJitter)
+	}
+	metrics := &attackMetrics{}
+	recorder := newPhaseRecorder(time.Now(), c.phases)
+	progress := live.start(runID)
+	defer live.finish(progress)
+	var results *resultsRecorder
+	if c.recordResults {
+		var err error
+		if results, err = newResultsRecorder(runID); err != nil {
+			log.Printf("not recording results: %v\n", err)
+		} else {
+			defer results.Close()
+		}
+	}
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Println("attack cancelled. stopping")
+			attacker.Stop()
+		case <-stopped:
+		}
+	}()
+	var attack <-chan *vegeta.Result
+	if c.rpc != nil {
+		log.Printf("calling %s on %s\n", c.rpc.method.GetFullyQualifiedName(), c.rpc.conns[0].Target())
+		defer c.rpc.close()
+		attack = attackGRPC(ctx, c.rpc, pacer, attackDuration, c.workers, c.baseData(requestBase), c.secretPaths, pool, routes)
+	} else if c.users > 0 {
+		attack = attackUsers(ctx, attacker, targeter, c.users, attackDuration, c.thinkTime, c.thinkJitter)
+	} else {
+		attack = attacker.Attack(targeter, pacer, attackDuration, "main")
+	}
+	for res := range attack {
+		if res.Error == vegeta.ErrNoTargets.Error() {
+			// the replay ran out of targets; not a failed request
+			log.Println("replayed every target")
+			continue
+		}
 		metrics.Add(res)
+		metrics.LatencyHistogram.Add(res.Latency)
+		recorder.Add(res)
+		progress.Add(res)
+		if results != nil {
+			results.Add(res)
+		}
 		if res.Error != "" {
 			fmt.Println(res.Error)
 		}
 	}
 	log.Println("completed attack session")
 	metrics.Close()
+	if len(c.phases) > 0 {
+		metrics.Phases = recorder.Close()
+	}
+	metrics.Routes = routes.Close()
 	return metrics
 }
 
@@ -141,9 +311,7 @@ func serveFunc(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var params argsModel
 	err := decoder.Decode(&params)
-	fmt.Printf("received request: %v\n", params)
-	secretPaths = params.SecretPaths
-	tokens = params.Tokens
+	log.Printf("received request: %s\n", params.summary())
 	if err == nil || err == io.EOF {
 		err = params.Validate()
 	}
@@ -151,8 +319,20 @@ func serveFunc(w http.ResponseWriter, r *http.Request) {
 		logAndReturnFail(w, "Error assembling required prameters: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	params.Apply()
-	metrics := doAttack()
+	config := params.config()
+	if params.GRPC != nil {
+		if config.rpc, err = newGRPCCall(params.GRPC); err != nil {
+			logAndReturnFail(w, "Error preparing grpc attack: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the attack stops early if the api cancels the run or hangs up
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	attacks.add(params.RunID, cancel)
+	defer attacks.remove(params.RunID)
+	metrics := doAttack(ctx, config)
 	if asBytes, err := json.Marshal(metrics); err != nil {
 		logAndReturnFail(w, "error marshalling metrics for response: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -162,80 +342,223 @@ func serveFunc(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-type targetGenerator struct {
-	root      string
-	paths     []string
-	pathsLen  int
-	tokens    []string
-	tokensLen int
-	data      []byte
-	readIndex int64
+// serveCancel stops the attack for the run given by the run query parameter,
+// or every running attack if no run is given
+func serveCancel(w http.ResponseWriter, r *http.Request) {
+	runID := r.URL.Query().Get("run")
+	n := attacks.cancel(runID)
+	log.Printf("cancelled %d attacks for run '%s'\n", n, runID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("{\"cancelled\":%d}", n)))
+}
+
+// attackSet tracks the cancel funcs of running attacks by run ID
+type attackSet struct {
+	sync.Mutex
+	cancels map[string]context.CancelFunc
 }
 
-func NewTargetReader(root string, paths []string, tokens []string) io.Reader {
-	return &targetGenerator{
-		root:      root,
-		paths:     paths,
-		pathsLen:  len(paths),
-		tokens:    tokens,
-		tokensLen: len(tokens),
+var attacks = &attackSet{cancels: map[string]context.CancelFunc{}}
+
+func (s *attackSet) add(runID string, cancel context.CancelFunc) {
+	s.Lock()
+	defer s.Unlock()
+	s.cancels[runID] = cancel
+}
+
+func (s *attackSet) remove(runID string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.cancels, runID)
+}
+
+func (s *attackSet) cancel(runID string) int {
+	s.Lock()
+	defer s.Unlock()
+	n := 0
+	for id, cancel := range s.cancels {
+		if runID != "" && id != runID {
+			continue
+		}
+		cancel()
+		n++
 	}
+	return n
 }
 
-func (t *targetGenerator) zeroReadIndex() {
-	if t.readIndex <= 0 {
-		return
+// targetGenerator fills in targets for the workload directly, rather than
+// encoding them to JSON for vegeta's JSON targeter to parse straight back
+type targetGenerator struct {
+	sync.Mutex
+	root     string
+	paths    []string
+	tokens   *tokenPool
+	workload *workload
+	bodySize int
+	// created are the secrets created during the attack and not yet
+	// deleted, and creating counts the creates awaiting a response
+	created  []string
+	creating int
+	// rootPath is the path of root as requests see it, trimmed from the
+	// urls of creates to get the path created
+	rootPath string
+	// routes label the url of each operation, and of reads and lists of
+	// each path in paths
+	routes     map[string]string
+	readRoutes []string
+	listRoutes []string
+
+	// headers are built once per token rather than per request, and rebuilt
+	// whenever a refresh swaps the pool's tokens
+	headerTokens []string
+	headers      []http.Header
+	writeHeaders []http.Header
+}
+
+func newTargetGenerator(root string, paths []string, tokens *tokenPool, mix *workload, bodySize int) *targetGenerator {
+	t := &targetGenerator{
+		root:       root,
+		paths:      make([]string, len(paths)),
+		tokens:     tokens,
+		workload:   mix,
+		bodySize:   bodySize,
+		routes:     map[string]string{},
+		readRoutes: make([]string, len(paths)),
+		listRoutes: make([]string, len(paths)),
+	}
+	for _, op := range workloadOps {
+		t.routes[op] = routeSuffix(op)
 	}
-	t.data = t.data[t.readIndex:]
-	t.readIndex = 0
+	if u, err := url.Parse(t.url("", "")); err == nil {
+		t.rootPath = u.Path
+	}
+	for ix, p := range paths {
+		t.paths[ix] = strings.TrimPrefix(p, "/")
+		t.readRoutes[ix] = routeSuffix(opRead + " " + pathTemplate(p))
+		t.listRoutes[ix] = routeSuffix(opList + " " + pathTemplate(path.Dir(t.paths[ix])) + "/")
+	}
+	return t
 }
 
-// TODO : can we optimize this? each target is around 600 bytes and
-// the attacker reads 4000 at a time...so need about 10 targets per invocation
-func (t *targetGenerator) pushTargetBuffer() {
-	t.zeroReadIndex()
-	path := strings.TrimPrefix(t.paths[fastrand.Intn(t.pathsLen)], "/")
-	header := http.Header{}
-	token := t.tokens[fastrand.Intn(t.tokensLen)]
-	header.Add("Authorization", token)
-	// fmt.Printf("generated target of %s and header %s...\n", path, token[:10])
-	target := vegeta.Target{
-		Method: "GET",
-		URL:    fmt.Sprintf("%s/%s", t.root, path),
-		Header: header,
-	}
-	m, _ := json.Marshal(target)
-	m = append(m, '\n')
-	t.data = append(t.data, m...)
+// Targeter returns a vegeta.Targeter that populates each target in place
+func (t *targetGenerator) Targeter() vegeta.Targeter {
+	return func(tgt *vegeta.Target) error {
+		if tgt == nil {
+			return vegeta.ErrNilTarget
+		}
+		t.Lock()
+		defer t.Unlock()
+		t.nextTarget(tgt)
+		return nil
+	}
 }
 
-// Read reads forever since it is a generator; no EOF needed
-// the JsonTargeter looks for '\n' as an object delimiter
-func (t *targetGenerator) Read(p []byte) (n int, err error) {
-	lenOut := len(p)
-	lenOut64 := int64(lenOut)
-	for lenOut64 > int64(len(t.data))-t.readIndex {
-		t.pushTargetBuffer()
-	}
-	copy(p, t.data[t.readIndex:])
-	t.readIndex += lenOut64
-	return lenOut, nil
+// header picks the prebuilt headers of a random token. Vegeta copies headers
+// into each request, so they can be shared between targets.
+func (t *targetGenerator) header(write bool) http.Header {
+	current := t.tokens.get()
+	if len(current) != len(t.headerTokens) || (len(current) > 0 && &current[0] != &t.headerTokens[0]) {
+		// the pool replaces its slice on every refresh
+		t.headerTokens = current
+		t.headers = make([]http.Header, len(current))
+		t.writeHeaders = make([]http.Header, len(current))
+		for ix, token := range current {
+			t.headers[ix] = http.Header{"Authorization": []string{token}}
+			t.writeHeaders[ix] = http.Header{
+				"Authorization": []string{token},
+				"Content-Type":  []string{"application/json"},
+			}
+		}
+	}
+	if len(t.headers) == 0 {
+		return nil
+	}
+	ix := fastrand.Intn(len(t.headers))
+	if write {
+		return t.writeHeaders[ix]
+	}
+	return t.headers[ix]
 }
 
 type argsModel struct {
+	RunID          string
 	Tenant         string
 	Domain         string
 	Rate           int
 	Duration       int
+	Phases         []loadPhase
 	SecretPaths    []string
 	Tokens         []string
+	Credentials    []tokenCredential
+	TokenURL       string
+	Targets        []vegeta.Target
+	LoopTargets    bool
+	RandomTargets  bool
+	Templates      []targetTemplate
 	StaticTargeter bool
 	Workers        int
+	RecordResults  bool
+	Workload       map[string]int
+	BodySize       int
+	// Users runs a closed model attack instead of following Rate or Phases.
+	// Think times are in milliseconds.
+	Users       int
+	ThinkTime   int
+	ThinkJitter int
+	// GRPC calls a gRPC method instead of the tenant's http api
+	GRPC *grpcConfig `json:",omitempty"`
 }
 
-func validateCmd() {
+// validateCmd checks the flags and builds the attack they ask for. In serve
+// mode every request to /command brings its own attack instead.
+func validateCmd() *attackConfig {
 	if *serve {
-		return
+		return nil
+	}
+	c := configFromFlags()
+	if *users < 0 || *thinkTime < 0 || *thinkJitter < 0 {
+		fmt.Println("error: --users, --think-time and --think-jitter must not be negative")
+		os.Exit(1)
+	}
+	if *grpcTarget != "" {
+		config, err := grpcConfigFromFlags()
+		if err == nil && *users > 0 {
+			err = errors.New("virtual users only make http requests")
+		}
+		if err == nil {
+			c.rpc, err = newGRPCCall(config)
+		}
+		if err != nil {
+			fmt.Printf("error: invalid grpc attack: %v\n", err)
+			os.Exit(1)
+		}
+		return c
+	}
+	if *targetsFile != "" {
+		var err error
+		if c.targets, err = loadTargetsFile(*targetsFile, *targetsFormat); err != nil {
+			fmt.Printf("error: failed to load --targets-file: %v\n", err)
+			os.Exit(1)
+		}
+		if len(c.targets) == 0 {
+			fmt.Println("error: --targets-file has no targets")
+			os.Exit(1)
+		}
+		return c
+	}
+	if *templatesFile != "" {
+		data, err := ioutil.ReadFile(*templatesFile)
+		if err == nil {
+			err = json.Unmarshal(data, &c.templates)
+		}
+		if err == nil {
+			_, _, err = compileTemplates(c.templates)
+		}
+		if err != nil {
+			fmt.Printf("error: invalid --templates-file: %v\n", err)
+			os.Exit(1)
+		}
+		return c
 	}
 	missing := ""
 	if *tenant == "" {
@@ -251,9 +574,48 @@ func validateCmd() {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if mix, err := newWorkload(*workloadWeights); err != nil {
+		fmt.Printf("error: invalid --workload: %v\n", err)
+		os.Exit(1)
+	} else if *staticTargeter && !mix.readOnly() {
+		fmt.Println("error: --static-targeter only supports a read workload")
+		os.Exit(1)
+	}
+	return c
 }
 
 func (a *argsModel) Validate() error {
+	// rejected whatever the mode, before the modes that return early
+	if a.BodySize < 0 {
+		return errors.New("body size must not be negative")
+	}
+	if a.Users < 0 {
+		return errors.New("users must not be negative")
+	}
+	if a.ThinkTime < 0 || a.ThinkJitter < 0 {
+		return errors.New("think time must not be negative")
+	}
+	if a.Users > 0 && len(a.Phases) > 0 {
+		return errors.New("virtual users can't follow load phases")
+	}
+	if a.GRPC != nil {
+		if a.Users > 0 {
+			return errors.New("virtual users only make http requests")
+		}
+		// the method is resolved before the attack
+		return a.GRPC.validate()
+	}
+	if len(a.Targets) > 0 {
+		// replayed targets carry everything they need
+		return nil
+	}
+	if len(a.Templates) > 0 {
+		// templates only need what they reference
+		if _, _, err := compileTemplates(a.Templates); err != nil {
+			return fmt.Errorf("invalid templates: %v", err)
+		}
+		return nil
+	}
 	if a.Tenant == "" {
 		return errors.New("must specify tenant")
 	}
@@ -263,26 +625,86 @@ func (a *argsModel) Validate() error {
 	if len(a.Tokens) == 0 {
 		return errors.New("no auth tokens specified")
 	}
+	if len(a.Workload) > 0 {
+		mix, err := newWorkload(a.Workload)
+		if err != nil {
+			return fmt.Errorf("invalid workload: %v", err)
+		}
+		if a.StaticTargeter && !mix.readOnly() {
+			return errors.New("the static targeter only supports a read workload")
+		}
+	}
 	return nil
 }
 
-func (a *argsModel) Apply() {
+// summary describes the request for the logs. It leaves out the
+// credentials, tokens, targets and templates the request carries.
+func (a *argsModel) summary() string {
+	mode := "workload"
+	switch {
+	case a.GRPC != nil:
+		mode = "grpc"
+	case len(a.Targets) > 0:
+		mode = "replay"
+	case len(a.Templates) > 0:
+		mode = "templates"
+	}
+	load := fmt.Sprintf("%d/s for %ds", a.Rate, a.Duration)
+	if a.Users > 0 {
+		load = fmt.Sprintf("%d users for %ds", a.Users, a.Duration)
+	} else if len(a.Phases) > 0 {
+		load = fmt.Sprintf("%d phases", len(a.Phases))
+	}
+	return fmt.Sprintf("run %s, %s, %s", a.RunID, mode, load)
+}
+
+// config builds the attack the request asks for, falling back on the
+// loadbot's flags for what it leaves out
+func (a *argsModel) config() *attackConfig {
+	c := defaultAttackConfig()
+	c.runID = a.RunID
 	if a.Tenant != "" {
-		*tenant = a.Tenant
+		c.tenant = a.Tenant
 	}
 	if a.Domain != "" {
-		*domain = a.Domain
+		c.domain = a.Domain
 	}
 	if a.Duration > 0 {
-		*duration = time.Duration(a.Duration) * time.Second
+		c.duration = time.Duration(a.Duration) * time.Second
 	}
 	if a.Rate > 0 {
-		*rate = a.Rate
+		c.rate = a.Rate
 	}
 	if a.Workers > 0 {
-		*workers = a.Workers
+		c.workers = a.Workers
 	}
 	if a.StaticTargeter {
-		*staticTargeter = a.StaticTargeter
+		c.staticTargeter = a.StaticTargeter
+	}
+	c.recordResults = a.RecordResults
+	if len(a.Workload) > 0 {
+		c.workload = a.Workload
+	}
+	if a.BodySize > 0 {
+		c.bodySize = a.BodySize
+	}
+	if len(a.SecretPaths) > 0 {
+		c.secretPaths = a.SecretPaths
+	}
+	if len(a.Tokens) > 0 {
+		c.tokens = a.Tokens
+	}
+	c.credentials = a.Credentials
+	c.tokenURL = a.TokenURL
+	c.phases = a.Phases
+	c.targets = a.Targets
+	c.loopTargets = a.LoopTargets
+	c.randomTargets = a.RandomTargets
+	c.templates = a.Templates
+	c.users = a.Users
+	if a.Users > 0 {
+		c.thinkTime = time.Duration(a.ThinkTime) * time.Millisecond
+		c.thinkJitter = time.Duration(a.ThinkJitter) * time.Millisecond
 	}
+	return c
 }
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/noahhai/kube-vegeta/histogram"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// loadPhase is one stage of a load profile, with rates already split for
+// this loadbot by the api
+//
+//	steady: hold Rate for Duration
+//	spike:  burst to Rate for Duration, then drop back to the rate the
+//	        previous phase ended at for Recovery (Duration if unset)
+//	ramp:   change linearly from From to To over Duration
+//	step:   climb from From to To in Steps equal steps over Duration
+type loadPhase struct {
+	Name     string
+	Type     string
+	Duration int
+	Rate     float64
+	From     float64
+	To       float64
+	Steps    int
+	Recovery int
+}
+
+// recovery is how long a spike spends back at the previous rate
+func (p *loadPhase) recovery() int {
+	if p.Type != "spike" {
+		return 0
+	}
+	if p.Recovery > 0 {
+		return p.Recovery
+	}
+	return p.Duration
+}
+
+// length is how long the phase runs, including a spike's recovery
+func (p *loadPhase) length() time.Duration {
+	return time.Duration(p.Duration+p.recovery()) * time.Second
+}
+
+// segment is a stretch of the profile over which the rate changes linearly
+type segment struct {
+	start    time.Duration
+	duration time.Duration
+	from, to float64
+}
+
+// phasePacer paces hits to follow a multi-phase load profile
+type phasePacer struct {
+	segments []segment
+	total    time.Duration
+}
+
+func newPhasePacer(phases []loadPhase) *phasePacer {
+	p := &phasePacer{}
+	// the rate the profile is at before each phase, which spikes return to
+	previous := 0.0
+	for _, phase := range phases {
+		d := time.Duration(phase.Duration) * time.Second
+		switch phase.Type {
+		case "spike":
+			p.add(d, phase.Rate, phase.Rate)
+			p.add(time.Duration(phase.recovery())*time.Second, previous, previous)
+			continue
+		case "ramp":
+			p.add(d, phase.From, phase.To)
+		case "step":
+			steps := phase.Steps
+			if steps < 2 {
+				steps = 2
+			}
+			stepDuration := d / time.Duration(steps)
+			for i := 0; i < steps; i++ {
+				rate := phase.From + (phase.To-phase.From)*float64(i)/float64(steps-1)
+				if i == steps-1 {
+					// last step absorbs any rounding so phases stay aligned
+					stepDuration = d - stepDuration*time.Duration(steps-1)
+				}
+				p.add(stepDuration, rate, rate)
+			}
+		default:
+			p.add(d, phase.Rate, phase.Rate)
+			previous = phase.Rate
+			continue
+		}
+		previous = phase.To
+	}
+	return p
+}
+
+func (p *phasePacer) add(d time.Duration, from, to float64) {
+	p.segments = append(p.segments, segment{
+		start:    p.total,
+		duration: d,
+		from:     from,
+		to:       to,
+	})
+	p.total += d
+}
+
+// hits is the number of hits the profile expects by elapsed
+func (p *phasePacer) hits(elapsed time.Duration) float64 {
+	hits := 0.0
+	for _, s := range p.segments {
+		if elapsed <= s.start {
+			break
+		}
+		t := elapsed - s.start
+		if t > s.duration {
+			t = s.duration
+		}
+		hits += s.hitsWithin(t.Seconds())
+	}
+	return hits
+}
+
+// hitsWithin integrates the segment's rate over its first t seconds
+func (s segment) hitsWithin(t float64) float64 {
+	d := s.duration.Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return s.from*t + (s.to-s.from)*t*t/(2*d)
+}
+
+// Pace implements vegeta.Pacer by solving for when the next hit is due
+func (p *phasePacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if elapsed >= p.total {
+		return 0, true
+	}
+	expected := p.hits(elapsed)
+	if float64(hits) < expected {
+		// running behind; hit straight away
+		return 0, false
+	}
+
+	target := float64(hits) + 1
+	for _, s := range p.segments {
+		end := s.start + s.duration
+		if end <= elapsed {
+			continue
+		}
+		startHits := p.hits(s.start)
+		if startHits+s.hitsWithin(s.duration.Seconds()) < target {
+			continue
+		}
+		// solve from*t + a*t^2/2 = target - startHits for t within the segment
+		need := target - startHits
+		a := (s.to - s.from) / s.duration.Seconds()
+		var t float64
+		if a == 0 {
+			t = need / s.from
+		} else {
+			t = (-s.from + math.Sqrt(s.from*s.from+2*a*need)) / a
+		}
+		due := s.start + time.Duration(t*float64(time.Second))
+		if due < elapsed {
+			return 0, false
+		}
+		return due - elapsed, false
+	}
+	// no more hits are due before the profile ends
+	return 0, true
+}
+
+// phaseMetrics are the metrics of the hits sent during one phase
+type phaseMetrics struct {
+	Name string `json:"name"`
+	vegeta.Metrics
+	LatencyHistogram histogram.Histogram `json:"latencyHistogram"`
+}
+
+// phaseRecorder sorts results into the phase they were sent in
+type phaseRecorder struct {
+	began  time.Time
+	ends   []time.Duration
+	phases []phaseMetrics
+}
+
+func newPhaseRecorder(began time.Time, phases []loadPhase) *phaseRecorder {
+	r := &phaseRecorder{began: began}
+	var end time.Duration
+	for ix, phase := range phases {
+		end += phase.length()
+		name := phase.Name
+		if name == "" {
+			name = fmt.Sprintf("%d-%s", ix, phase.Type)
+		}
+		r.ends = append(r.ends, end)
+		r.phases = append(r.phases, phaseMetrics{Name: name})
+	}
+	return r
+}
+
+func (r *phaseRecorder) Add(res *vegeta.Result) {
+	if len(r.phases) == 0 {
+		return
+	}
+	offset := res.Timestamp.Sub(r.began)
+	ix := 0
+	for ix < len(r.ends)-1 && offset >= r.ends[ix] {
+		ix++
+	}
+	r.phases[ix].Add(res)
+	r.phases[ix].LatencyHistogram.Add(res.Latency)
+}
+
+func (r *phaseRecorder) Close() []phaseMetrics {
+	for ix := range r.phases {
+		// closing an empty phase would divide by zero
+		if r.phases[ix].Requests > 0 {
+			r.phases[ix].Close()
+		}
+	}
+	return r.phases
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// paceAll follows the pacer's waits through its whole profile, as vegeta's
+// attacker does, and returns when each hit was sent
+func paceAll(t *testing.T, p *phasePacer) []time.Duration {
+	sent := []time.Duration{}
+	var elapsed time.Duration
+	for {
+		wait, stop := p.Pace(elapsed, uint64(len(sent)))
+		if stop {
+			return sent
+		}
+		if wait < 0 {
+			t.Fatalf("negative wait %s after %d hits", wait, len(sent))
+		}
+		elapsed += wait
+		sent = append(sent, elapsed)
+		if len(sent) > 100000 {
+			t.Fatal("pacer never stopped")
+		}
+	}
+}
+
+func TestPhasePacerHits(t *testing.T) {
+	tests := []struct {
+		name   string
+		phases []loadPhase
+		total  time.Duration
+		hits   int
+		// the first hit is due no sooner than this
+		first time.Duration
+	}{
+		{
+			name:   "steady",
+			phases: []loadPhase{{Type: "steady", Duration: 2, Rate: 10}},
+			total:  2 * time.Second,
+			hits:   20,
+		},
+		{
+			name:   "ramp up from zero",
+			phases: []loadPhase{{Type: "ramp", Duration: 2, From: 0, To: 10}},
+			total:  2 * time.Second,
+			hits:   10,
+		},
+		{
+			name:   "ramp down to zero",
+			phases: []loadPhase{{Type: "ramp", Duration: 4, From: 10, To: 0}},
+			total:  4 * time.Second,
+			hits:   20,
+		},
+		{
+			name: "zero rate then steady",
+			phases: []loadPhase{
+				{Type: "steady", Duration: 2, Rate: 0},
+				{Type: "steady", Duration: 2, Rate: 5},
+			},
+			total: 4 * time.Second,
+			hits:  10,
+			first: 2 * time.Second,
+		},
+		{
+			name: "steady then zero rate",
+			phases: []loadPhase{
+				{Type: "steady", Duration: 1, Rate: 5},
+				{Type: "steady", Duration: 3, Rate: 0},
+			},
+			total: 4 * time.Second,
+			hits:  5,
+		},
+		{
+			name:   "only zero rate",
+			phases: []loadPhase{{Type: "steady", Duration: 3, Rate: 0}},
+			total:  3 * time.Second,
+			hits:   0,
+		},
+		{
+			name: "spike recovers to previous rate",
+			phases: []loadPhase{
+				{Type: "steady", Duration: 1, Rate: 2},
+				{Type: "spike", Duration: 1, Rate: 10, Recovery: 2},
+			},
+			total: 4 * time.Second,
+			hits:  2 + 10 + 4,
+		},
+		{
+			name:   "spike recovers for its duration by default",
+			phases: []loadPhase{{Type: "spike", Duration: 2, Rate: 5}},
+			total:  4 * time.Second,
+			hits:   10,
+		},
+		{
+			name:   "step",
+			phases: []loadPhase{{Type: "step", Duration: 3, From: 2, To: 6, Steps: 3}},
+			total:  3 * time.Second,
+			hits:   2 + 4 + 6,
+		},
+		{
+			name: "ramp then zero rate then ramp",
+			phases: []loadPhase{
+				{Type: "ramp", Duration: 2, From: 0, To: 4},
+				{Type: "steady", Duration: 1, Rate: 0},
+				{Type: "ramp", Duration: 2, From: 4, To: 0},
+			},
+			total: 5 * time.Second,
+			hits:  8,
+		},
+	}
+	for _, test := range tests {
+		p := newPhasePacer(test.phases)
+		if p.total != test.total {
+			t.Errorf("%s: profile lasts %s, want %s", test.name, p.total, test.total)
+		}
+		if expected := p.hits(p.total); math.Abs(expected-float64(test.hits)) > 1e-9 {
+			t.Errorf("%s: profile expects %v hits, want %d", test.name, expected, test.hits)
+		}
+		sent := paceAll(t, p)
+		if len(sent) != test.hits {
+			t.Errorf("%s: paced %d hits, want %d", test.name, len(sent), test.hits)
+		}
+		if len(sent) > 0 && sent[0] < test.first {
+			t.Errorf("%s: first hit at %s, want no sooner than %s", test.name, sent[0], test.first)
+		}
+		for ix, at := range sent {
+			if at > p.total {
+				t.Errorf("%s: hit %d at %s, after the profile ends", test.name, ix, at)
+				break
+			}
+		}
+	}
+}
+
+func TestPhasePacerExpectedHitsMidPhase(t *testing.T) {
+	p := newPhasePacer([]loadPhase{
+		{Type: "ramp", Duration: 2, From: 0, To: 10},
+		{Type: "steady", Duration: 2, Rate: 0},
+		{Type: "steady", Duration: 2, Rate: 4},
+	})
+	tests := []struct {
+		elapsed time.Duration
+		hits    float64
+	}{
+		{0, 0},
+		{time.Second, 2.5},
+		{2 * time.Second, 10},
+		{3 * time.Second, 10},
+		{4 * time.Second, 10},
+		{5 * time.Second, 14},
+		{6 * time.Second, 18},
+		{10 * time.Second, 18},
+	}
+	for _, test := range tests {
+		if got := p.hits(test.elapsed); math.Abs(got-test.hits) > 1e-9 {
+			t.Errorf("hits by %s = %v, want %v", test.elapsed, got, test.hits)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "loadtest",
+		Subsystem: "loadbot",
+		Name:      "requests_total",
+		Help:      "Requests sent by the loadbot by run and status code. Requests that got no response have code 0.",
+	}, []string{"run", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "loadtest",
+		Subsystem: "loadbot",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the loadbot's requests by run.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"run"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration)
+}
+
+// runExporter exports the results of one attack under its run ID. The series
+// are dropped once the run is no longer kept for /live, so run IDs don't pile
+// up in the scrapes of a long lived loadbot.
+type runExporter struct {
+	runID    string
+	duration prometheus.Observer
+	requests map[uint16]prometheus.Counter
+}
+
+func newRunExporter(runID string) *runExporter {
+	return &runExporter{
+		runID:    runID,
+		duration: requestDuration.WithLabelValues(runID),
+		requests: map[uint16]prometheus.Counter{},
+	}
+}
+
+// add exports a result. Callers serialize calls.
+func (e *runExporter) add(res *vegeta.Result) {
+	counter, ok := e.requests[res.Code]
+	if !ok {
+		counter = requestsTotal.WithLabelValues(e.runID, strconv.Itoa(int(res.Code)))
+		e.requests[res.Code] = counter
+	}
+	counter.Inc()
+	e.duration.Observe(res.Latency.Seconds())
+}
+
+func (e *runExporter) forget() {
+	requestDuration.DeleteLabelValues(e.runID)
+	for code := range e.requests {
+		requestsTotal.DeleteLabelValues(e.runID, strconv.Itoa(int(code)))
+	}
+}
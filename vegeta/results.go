@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/noahhai/kube-vegeta/formats"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+var (
+	recordResults    = flag.Bool("record-results", false, "Record every result of the attack so it can be fetched from /results")
+	resultsDir       = flag.String("results-dir", "results", "Directory recorded results are written to")
+	resultsRetention = flag.Duration("results-retention", 24*time.Hour, "How long recorded results are kept")
+
+	invalidRunChars = regexp.MustCompile("[^a-zA-Z0-9-]+")
+)
+
+// resultsRecorder writes every result of an attack to disk in vegeta's binary
+// encoding so it can later be fed to vegeta report or plot
+type resultsRecorder struct {
+	file   *os.File
+	buffer *bufio.Writer
+	encode vegeta.Encoder
+}
+
+func resultsPath(runID string) string {
+	if runID == "" {
+		runID = "local"
+	}
+	return path.Join(*resultsDir, invalidRunChars.ReplaceAllString(runID, "-")+".bin")
+}
+
+func newResultsRecorder(runID string) (*resultsRecorder, error) {
+	if err := os.MkdirAll(*resultsDir, 0700); err != nil {
+		return nil, err
+	}
+	pruneResults()
+	file, err := os.Create(resultsPath(runID))
+	if err != nil {
+		return nil, err
+	}
+	buffer := bufio.NewWriter(file)
+	return &resultsRecorder{
+		file:   file,
+		buffer: buffer,
+		encode: vegeta.NewEncoder(buffer),
+	}, nil
+}
+
+func (r *resultsRecorder) Add(res *vegeta.Result) {
+	if err := r.encode.Encode(res); err != nil {
+		log.Printf("failed to record result: %v\n", err)
+	}
+}
+
+func (r *resultsRecorder) Close() {
+	if err := r.buffer.Flush(); err != nil {
+		log.Printf("failed to flush recorded results: %v\n", err)
+	}
+	r.file.Close()
+}
+
+// pruneResults removes recordings older than the retention period
+func pruneResults() {
+	files, err := ioutil.ReadDir(*resultsDir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-*resultsRetention)
+	for _, f := range files {
+		if !f.IsDir() && f.ModTime().Before(cutoff) {
+			os.Remove(path.Join(*resultsDir, f.Name()))
+		}
+	}
+}
+
+// serveResults handles GET /results?run=&format= with the recorded results of
+// the run in vegeta's bin, csv or json encoding
+func serveResults(w http.ResponseWriter, r *http.Request) {
+	runID := r.URL.Query().Get("run")
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	file, err := os.Open(resultsPath(runID))
+	if os.IsNotExist(err) {
+		logAndReturnFail(w, "no results recorded for run '"+runID+"'", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logAndReturnFail(w, "failed to open results: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	if format == "" || format == "bin" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.Copy(w, file)
+		return
+	}
+	buffer := bufio.NewWriter(w)
+	encode, err := formats.NewResultsEncoder(format, buffer)
+	if err != nil {
+		logAndReturnFail(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	decode := vegeta.NewDecoder(bufio.NewReader(file))
+	for {
+		var res vegeta.Result
+		if err := decode.Decode(&res); err == io.EOF {
+			break
+		} else if err != nil {
+			// the response has started so all we can do is stop
+			log.Printf("failed to decode recorded results: %v\n", err)
+			break
+		}
+		if err := encode.Encode(&res); err != nil {
+			log.Printf("failed to encode results: %v\n", err)
+			break
+		}
+	}
+	buffer.Flush()
+}
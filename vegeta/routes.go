@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noahhai/kube-vegeta/histogram"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// maxRoutes caps how many routes are broken down, since each keeps its own
+// metrics. Requests to any further routes are counted under otherRoute.
+const (
+	maxRoutes  = 100
+	otherRoute = "other"
+)
+
+// withRoute labels a target's URL with the route its metrics are grouped
+// under. The label rides in the URL fragment, which is never sent, so that
+// labelling costs nothing per request when the URL is built up front.
+func withRoute(rawURL, route string) string {
+	return rawURL + routeSuffix(route)
+}
+
+func routeSuffix(route string) string {
+	return "#" + url.PathEscape(route)
+}
+
+// pathTemplate stands in a * for every segment of the path, so secrets at
+// the same depth of the tree share a route
+func pathTemplate(p string) string {
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	for ix := range segments {
+		segments[ix] = "*"
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// replayRoute names a replayed request by its method and path, with any
+// segment containing a digit, like an id, replaced by a *
+func replayRoute(method, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return method
+	}
+	segments := strings.Split(u.Path, "/")
+	for ix, s := range segments {
+		if strings.ContainsAny(s, "0123456789") {
+			segments[ix] = "*"
+		}
+	}
+	return method + " " + strings.Join(segments, "/")
+}
+
+// routeMetrics are the metrics of the requests to one route
+type routeMetrics struct {
+	Name string `json:"name"`
+	vegeta.Metrics
+	LatencyHistogram histogram.Histogram `json:"latencyHistogram"`
+}
+
+// routeRecorder is an http.RoundTripper that measures every labelled request
+// under its route. Vegeta's results don't say which target they came from,
+// so each request is timed here from sending it until its body is read,
+// the way vegeta times the whole hit.
+type routeRecorder struct {
+	sync.Mutex
+	next   http.RoundTripper
+	routes map[string]*routeMetrics
+	// observe, if set, is told the status code of each labelled request
+	// once its response arrives, 0 if it failed
+	observe func(req *http.Request, code int)
+}
+
+// newRouteClient returns a client like vegeta's default one that records
+// the metrics of each route
+func newRouteClient() (*http.Client, *routeRecorder) {
+	dialer := &net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: vegeta.DefaultLocalAddr.IP, Zone: vegeta.DefaultLocalAddr.Zone},
+		KeepAlive: 30 * time.Second,
+	}
+	recorder := &routeRecorder{
+		next: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			Dial:                dialer.Dial,
+			TLSClientConfig:     vegeta.DefaultTLSConfig,
+			MaxIdleConnsPerHost: vegeta.DefaultConnections,
+		},
+		routes: map[string]*routeMetrics{},
+	}
+	return &http.Client{Timeout: vegeta.DefaultTimeout, Transport: recorder}, recorder
+}
+
+func (r *routeRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := req.URL.Fragment
+	if route == "" {
+		return r.next.RoundTrip(req)
+	}
+	res := &vegeta.Result{Timestamp: time.Now()}
+	if req.ContentLength != -1 {
+		res.BytesOut = uint64(req.ContentLength)
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		res.Error = err.Error()
+		res.Latency = time.Since(res.Timestamp)
+		r.add(route, res)
+		if r.observe != nil {
+			r.observe(req, 0)
+		}
+		return nil, err
+	}
+	if r.observe != nil {
+		r.observe(req, resp.StatusCode)
+	}
+	if res.Code = uint16(resp.StatusCode); res.Code < 200 || res.Code >= 400 {
+		res.Error = resp.Status
+	}
+	resp.Body = &routeBody{ReadCloser: resp.Body, route: route, res: res, recorder: r}
+	return resp, nil
+}
+
+func (r *routeRecorder) add(route string, res *vegeta.Result) {
+	r.Lock()
+	defer r.Unlock()
+	m, ok := r.routes[route]
+	if !ok {
+		if len(r.routes) >= maxRoutes {
+			route = otherRoute
+		}
+		if m, ok = r.routes[route]; !ok {
+			m = &routeMetrics{Name: route}
+			r.routes[route] = m
+		}
+	}
+	m.Add(res)
+	m.LatencyHistogram.Add(res.Latency)
+}
+
+// Close returns the metrics of each route, sorted by name
+func (r *routeRecorder) Close() []routeMetrics {
+	r.Lock()
+	defer r.Unlock()
+	routes := make([]routeMetrics, 0, len(r.routes))
+	for _, m := range r.routes {
+		m.Close()
+		routes = append(routes, *m)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Name < routes[j].Name })
+	return routes
+}
+
+// routeBody finishes timing a request once its body has been read
+type routeBody struct {
+	io.ReadCloser
+	route    string
+	res      *vegeta.Result
+	recorder *routeRecorder
+	done     bool
+}
+
+func (b *routeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.res.BytesIn += uint64(n)
+	if err != nil {
+		if err != io.EOF {
+			b.res.Error = err.Error()
+		}
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *routeBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *routeBody) finish() {
+	if b.done {
+		return
+	}
+	b.done = true
+	b.res.Latency = time.Since(b.res.Timestamp)
+	b.recorder.add(b.route, b.res)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+const benchmarkRoot = "https://bench.example.com/v1"
+
+func benchmarkPaths() []string {
+	paths := make([]string, 10)
+	for ix := range paths {
+		paths[ix] = fmt.Sprintf("/secrets/bench/secret-%d", ix)
+	}
+	return paths
+}
+
+func benchmarkGenerator(b *testing.B) *targetGenerator {
+	mix, err := newWorkload(map[string]int{opRead: 1})
+	if err != nil {
+		b.Fatal(err)
+	}
+	tokens := newTokenPool([]string{"token-a", "token-b", "token-c", "token-d"})
+	return newTargetGenerator(benchmarkRoot, benchmarkPaths(), tokens, mix, 0)
+}
+
+// jsonTargetReader is how targets used to reach vegeta: generated, encoded as
+// JSON lines and decoded again by vegeta.NewJSONTargeter. It is kept here to
+// compare against.
+type jsonTargetReader struct {
+	generator *targetGenerator
+	data      []byte
+}
+
+// Read never ends since the generator doesn't
+func (r *jsonTargetReader) Read(p []byte) (int, error) {
+	for len(r.data) < len(p) {
+		var target vegeta.Target
+		r.generator.nextTarget(&target)
+		encoded, _ := json.Marshal(target)
+		r.data = append(r.data, encoded...)
+		r.data = append(r.data, '\n')
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func benchmarkTargeter(b *testing.B, targeter vegeta.Targeter) {
+	b.ReportAllocs()
+	var target vegeta.Target
+	for i := 0; i < b.N; i++ {
+		if err := targeter(&target); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTargetGenerator(b *testing.B) {
+	benchmarkTargeter(b, benchmarkGenerator(b).Targeter())
+}
+
+func BenchmarkJSONTargeter(b *testing.B) {
+	var reader io.Reader = &jsonTargetReader{generator: benchmarkGenerator(b)}
+	benchmarkTargeter(b, vegeta.NewJSONTargeter(reader, nil, nil))
+}
+
+func BenchmarkStaticTargeter(b *testing.B) {
+	targets := []vegeta.Target{}
+	for _, path := range benchmarkPaths() {
+		targets = append(targets, vegeta.Target{
+			Method: "GET",
+			URL:    benchmarkRoot + path,
+		})
+	}
+	benchmarkTargeter(b, vegeta.NewStaticTargeter(targets...))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"sync"
+
+	"github.com/NebulousLabs/fastrand"
+	flag "github.com/spf13/pflag"
+
+	"github.com/noahhai/kube-vegeta/formats"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+var (
+	targetsFile   = flag.String("targets-file", "", "HAR or JSONL file of targets to replay instead of reading secrets")
+	targetsFormat = flag.String("targets-format", "", "Format of --targets-file [har|jsonl]. Guessed from the extension if not given")
+	loopTargets   = flag.Bool("loop-targets", false, "Start the targets over when they run out rather than ending the attack")
+	randomTargets = flag.Bool("random-targets", false, "Pick targets at random rather than in order")
+)
+
+// newReplayTargeter replays the targets in order, or at random. Without loop
+// an in order replay stops the attack with vegeta.ErrNoTargets once every
+// target has been sent.
+func newReplayTargeter(targets []vegeta.Target, loop, random bool) vegeta.Targeter {
+	labelled := make([]vegeta.Target, len(targets))
+	for ix, t := range targets {
+		t.URL = withRoute(t.URL, replayRoute(t.Method, t.URL))
+		labelled[ix] = t
+	}
+	targets = labelled
+	var lock sync.Mutex
+	next := 0
+	return func(tgt *vegeta.Target) error {
+		if tgt == nil {
+			return vegeta.ErrNilTarget
+		}
+		if random {
+			*tgt = targets[fastrand.Intn(len(targets))]
+			return nil
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if next == len(targets) {
+			if !loop {
+				return vegeta.ErrNoTargets
+			}
+			next = 0
+		}
+		*tgt = targets[next]
+		next++
+		return nil
+	}
+}
+
+// loadTargetsFile reads targets from a HAR export or a JSONL file of vegeta targets
+func loadTargetsFile(file, format string) ([]vegeta.Target, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if format == "" {
+		format = formats.TargetsFormatOf(file)
+	}
+	return formats.ParseTargets(format, f)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/NebulousLabs/fastrand"
+	"github.com/icrowley/fake"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// targetTemplate describes requests with go templates. Every field is
+// rendered per request against a templateData, e.g.
+//
+//	URL:    {{.Root}}/secrets/{{.Path}}
+//	Header: Authorization: Bearer {{.Token}}
+//	Body:   {"data": "{{randString 100}}", "id": "{{uuid}}"}
+type targetTemplate struct {
+	// Name is the route the template's requests are broken down under.
+	// Defaults to the method and url template.
+	Name   string
+	Method string
+	URL    string
+	Header map[string]string
+	Body   string
+	// Weight is how often the template is picked relative to the others
+	Weight int
+}
+
+// templateData are the variables a template can reference
+type templateData struct {
+	Root   string
+	Tenant string
+	Domain string
+	// Path is a random secret path, without a leading slash
+	Path string
+	// Token is a random auth token
+	Token string
+	// Seq counts the requests made from the templates during the attack
+	Seq uint64
+}
+
+// templateFuncs are the generators available to templates
+var templateFuncs = template.FuncMap{
+	"uuid":       newUUID,
+	"randInt":    randInt,
+	"randString": randomString,
+	"pick":       pick,
+	"timestamp":  func() int64 { return time.Now().Unix() },
+	"name":       fake.FullName,
+	"username":   fake.UserName,
+	"email":      fake.EmailAddress,
+	"company":    fake.Company,
+	"word":       fake.Word,
+	"sentence":   fake.Sentence,
+}
+
+func newUUID() string {
+	b := fastrand.Bytes(16)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// randInt returns a random number in [min, max)
+func randInt(min, max int) int {
+	if max <= min {
+		return min
+	}
+	return min + fastrand.Intn(max-min)
+}
+
+func pick(choices ...string) string {
+	if len(choices) == 0 {
+		return ""
+	}
+	return choices[fastrand.Intn(len(choices))]
+}
+
+// compiledTemplate is a targetTemplate parsed once for the whole attack
+type compiledTemplate struct {
+	method *template.Template
+	url    *template.Template
+	header map[string]*template.Template
+	body   *template.Template
+	// route is appended to every url to label it
+	route string
+}
+
+func compileTemplate(ix int, t targetTemplate) (*compiledTemplate, error) {
+	parse := func(field, text string) (*template.Template, error) {
+		parsed, err := template.New(fmt.Sprintf("%d.%s", ix, field)).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template %d: %s: %v", ix, field, err)
+		}
+		return parsed, nil
+	}
+	if t.URL == "" {
+		return nil, fmt.Errorf("template %d: url is required", ix)
+	}
+	if t.Weight < 0 {
+		return nil, fmt.Errorf("template %d: weight must not be negative", ix)
+	}
+	method := t.Method
+	if method == "" {
+		method = "GET"
+	}
+	route := t.Name
+	if route == "" {
+		route = strings.ToUpper(method) + " " + t.URL
+	}
+	c := &compiledTemplate{
+		header: map[string]*template.Template{},
+		route:  routeSuffix(route),
+	}
+	var err error
+	if c.method, err = parse("method", method); err != nil {
+		return nil, err
+	}
+	if c.url, err = parse("url", t.URL); err != nil {
+		return nil, err
+	}
+	if c.body, err = parse("body", t.Body); err != nil {
+		return nil, err
+	}
+	for name, value := range t.Header {
+		if c.header[name], err = parse("header."+name, value); err != nil {
+			return nil, err
+		}
+	}
+	// a template that fails to render would stop the attack, so render one
+	// up front to catch references to unknown variables
+	var tgt vegeta.Target
+	if err := c.target(&templateData{}, &tgt); err != nil {
+		return nil, fmt.Errorf("template %d: %v", ix, err)
+	}
+	return c, nil
+}
+
+func render(t *template.Template, data *templateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c *compiledTemplate) target(data *templateData, tgt *vegeta.Target) error {
+	method, err := render(c.method, data)
+	if err != nil {
+		return err
+	}
+	url, err := render(c.url, data)
+	if err != nil {
+		return err
+	}
+	body, err := render(c.body, data)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	for name, t := range c.header {
+		value, err := render(t, data)
+		if err != nil {
+			return err
+		}
+		header.Set(name, value)
+	}
+	*tgt = vegeta.Target{
+		Method: strings.ToUpper(strings.TrimSpace(method)),
+		URL:    url + c.route,
+		Header: header,
+	}
+	if body != "" {
+		tgt.Body = []byte(body)
+	}
+	return nil
+}
+
+// compileTemplates parses every template, reporting the first that is invalid
+func compileTemplates(templates []targetTemplate) ([]*compiledTemplate, []int, error) {
+	compiled := make([]*compiledTemplate, 0, len(templates))
+	cumulative := []int{}
+	total := 0
+	for ix, t := range templates {
+		c, err := compileTemplate(ix, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		weight := t.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		total += weight
+		compiled = append(compiled, c)
+		cumulative = append(cumulative, total)
+	}
+	return compiled, cumulative, nil
+}
+
+// newTemplateTargeter renders a target from a template picked by weight for
+// every hit. The templates are compiled once up front.
+func newTemplateTargeter(templates []targetTemplate, base templateData, paths []string, tokens *tokenPool) (vegeta.Targeter, error) {
+	compiled, cumulative, err := compileTemplates(templates)
+	if err != nil {
+		return nil, err
+	}
+	if len(compiled) == 0 {
+		return nil, fmt.Errorf("no templates")
+	}
+	total := cumulative[len(cumulative)-1]
+	var seq uint64
+	return func(tgt *vegeta.Target) error {
+		if tgt == nil {
+			return vegeta.ErrNilTarget
+		}
+		data := base
+		data.Seq = atomic.AddUint64(&seq, 1) - 1
+		if len(paths) > 0 {
+			data.Path = strings.TrimPrefix(paths[fastrand.Intn(len(paths))], "/")
+		}
+		if current := tokens.get(); len(current) > 0 {
+			data.Token = current[fastrand.Intn(len(current))]
+		}
+		n := fastrand.Intn(total)
+		ix := sort.Search(len(cumulative), func(i int) bool { return cumulative[i] > n })
+		return compiled[ix].target(&data, tgt)
+	}, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	refreshBefore = flag.Duration("refresh-before", time.Minute, "How long before a token expires to refresh it")
+	refreshRetry  = flag.Duration("refresh-retry", 10*time.Second, "How long to wait before retrying a failed token refresh")
+)
+
+// tokenCredential is an access token along with what is needed to refresh it
+type tokenCredential struct {
+	AccessToken  string
+	RefreshToken string
+	// ExpiresAt is zero when the token doesn't expire
+	ExpiresAt time.Time
+}
+
+// tokenPool holds the tokens requests are signed with. Refreshed tokens are
+// swapped in by replacing the whole slice so the attack never waits on a lock.
+type tokenPool struct {
+	sync.Mutex
+	tokens atomic.Value
+}
+
+func newTokenPool(tokens []string) *tokenPool {
+	p := &tokenPool{}
+	p.tokens.Store(tokens)
+	return p
+}
+
+func (p *tokenPool) get() []string {
+	return p.tokens.Load().([]string)
+}
+
+func (p *tokenPool) set(ix int, token string) {
+	p.Lock()
+	defer p.Unlock()
+	current := p.get()
+	next := make([]string, len(current))
+	copy(next, current)
+	next[ix] = token
+	p.tokens.Store(next)
+}
+
+// tokenRefresher keeps each credential's token in the pool fresh
+type tokenRefresher struct {
+	pool   *tokenPool
+	url    string
+	client *http.Client
+}
+
+// refreshTokens refreshes the pool's tokens in the background until ctx is
+// done. Credentials line up with the pool's tokens; those without a refresh
+// token or expiry are left alone.
+func refreshTokens(ctx context.Context, pool *tokenPool, url string, credentials []tokenCredential) {
+	if url == "" {
+		return
+	}
+	r := &tokenRefresher{
+		pool:   pool,
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	n := 0
+	for ix, c := range credentials {
+		if c.RefreshToken == "" || c.ExpiresAt.IsZero() || ix >= len(pool.get()) {
+			continue
+		}
+		n++
+		go r.keepFresh(ctx, ix, c)
+	}
+	if n > 0 {
+		log.Printf("refreshing %d tokens in the background\n", n)
+	}
+}
+
+func (r *tokenRefresher) keepFresh(ctx context.Context, ix int, c tokenCredential) {
+	due := c.ExpiresAt.Add(-*refreshBefore)
+	for {
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		next, err := r.refresh(c.RefreshToken)
+		if err != nil {
+			log.Printf("failed to refresh token %d, retrying in %s: %v\n", ix, *refreshRetry, err)
+			due = time.Now().Add(*refreshRetry)
+			continue
+		}
+		if next.RefreshToken != "" {
+			c.RefreshToken = next.RefreshToken
+		}
+		c.AccessToken = next.AccessToken
+		r.pool.set(ix, c.AccessToken)
+		if next.ExpiresIn <= 0 {
+			// the new token doesn't expire
+			return
+		}
+		lifetime := time.Duration(next.ExpiresIn) * time.Second
+		c.ExpiresAt = time.Now().Add(lifetime)
+		// short lived tokens are refreshed half way through rather than
+		// straight away again
+		lead := *refreshBefore
+		if lead > lifetime/2 {
+			lead = lifetime / 2
+		}
+		due = c.ExpiresAt.Add(-lead)
+	}
+}
+
+// tokenResult is the token endpoint's response
+type tokenResult struct {
+	AccessToken  string
+	ExpiresIn    int
+	RefreshToken string
+}
+
+func (r *tokenRefresher) refresh(refreshToken string) (*tokenResult, error) {
+	body, _ := json.Marshal(map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": refreshToken,
+	})
+	resp, err := r.client.Post(r.url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(data))
+	}
+	var tr tokenResult
+	if err := json.Unmarshal(data, &tr); err != nil {
+		return nil, err
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("no access token in refresh response")
+	}
+	return &tr, nil
+}
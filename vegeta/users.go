@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/NebulousLabs/fastrand"
+	flag "github.com/spf13/pflag"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+var (
+	users       = flag.Int("users", 0, "Number of virtual users that each send a request, wait for the response and think before the next. Replaces --rate when set")
+	thinkTime   = flag.Duration("think-time", time.Second, "How long a virtual user waits after a response before its next request")
+	thinkJitter = flag.Duration("think-jitter", 0, "Up to how much longer than --think-time a virtual user waits, picked at random each time")
+)
+
+// thinkPacer paces one virtual user: a hit is only due once the user has
+// seen the response to its last one and finished thinking
+type thinkPacer struct {
+	began    time.Time
+	duration time.Duration
+	ready    chan struct{}
+	done     <-chan struct{}
+}
+
+func (p *thinkPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if hits > 0 {
+		select {
+		case <-p.ready:
+		case <-p.done:
+			return 0, true
+		}
+	}
+	// the attacker only checks the duration before pacing, and the user may
+	// have been waiting well past it
+	if p.duration > 0 && time.Since(p.began) >= p.duration {
+		return 0, true
+	}
+	return 0, false
+}
+
+// attackUsers runs a closed model attack: every user loops through the
+// targets one request at a time, so the arrival rate drops as the server
+// slows down, like real clients. The users share the attacker, and so its
+// connections, and their results are merged onto the returned channel.
+func attackUsers(ctx context.Context, attacker *vegeta.Attacker, targeter vegeta.Targeter, n int, du, think, jitter time.Duration) <-chan *vegeta.Result {
+	out := make(chan *vegeta.Result)
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+	began := time.Now()
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			pacer := &thinkPacer{
+				began:    began,
+				duration: du,
+				// one hit is outstanding at a time, so this never blocks
+				ready: make(chan struct{}, 1),
+				done:  ctx.Done(),
+			}
+			for res := range attacker.Attack(targeter, pacer, du, "main") {
+				out <- res
+				wait := think
+				if jitter > 0 {
+					wait += time.Duration(fastrand.Uint64n(uint64(jitter)))
+				}
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+				}
+				pacer.ready <- struct{}{}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/NebulousLabs/fastrand"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// the operations a workload can mix
+const (
+	opRead       = "read"
+	opList       = "list"
+	opUpdate     = "update"
+	opCreate     = "create"
+	opDelete     = "delete"
+	opPermission = "permission"
+)
+
+var workloadOps = []string{opRead, opList, opUpdate, opCreate, opDelete, opPermission}
+
+const bodyChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// maxCreated caps the secrets an attack keeps created at once, counting
+// creates still in flight, so a create-heavy mix can't grow without bound
+const maxCreated = 1000
+
+// workload picks operations at random in proportion to their weights
+type workload struct {
+	ops []string
+	// cumulative[i] is the sum of the weights of ops[:i+1]
+	cumulative []int
+	total      int
+}
+
+func newWorkload(weights map[string]int) (*workload, error) {
+	if len(weights) == 0 {
+		weights = map[string]int{opRead: 1}
+	}
+	w := &workload{}
+	for _, op := range workloadOps {
+		weight, ok := weights[op]
+		if !ok {
+			continue
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("weight of %s must not be negative", op)
+		}
+		if weight == 0 {
+			continue
+		}
+		w.total += weight
+		w.ops = append(w.ops, op)
+		w.cumulative = append(w.cumulative, w.total)
+	}
+	for op := range weights {
+		if !isWorkloadOp(op) {
+			return nil, fmt.Errorf("unknown operation '%s'. must be one of %s", op, strings.Join(workloadOps, ", "))
+		}
+	}
+	if w.total == 0 {
+		return nil, fmt.Errorf("workload has no operations with a positive weight")
+	}
+	return w, nil
+}
+
+func isWorkloadOp(op string) bool {
+	for _, known := range workloadOps {
+		if op == known {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnly reports whether the workload only ever reads
+func (w *workload) readOnly() bool {
+	return len(w.ops) == 1 && w.ops[0] == opRead
+}
+
+func (w *workload) pick() string {
+	n := fastrand.Intn(w.total)
+	ix := sort.Search(len(w.cumulative), func(i int) bool { return w.cumulative[i] > n })
+	return w.ops[ix]
+}
+
+// nextTarget fills in the request for an operation picked from the workload.
+// Writes go to secrets created during the attack so the data populated by
+// setup, which reads rely on, is never changed or removed:
+//
+//	read:       GET    {root}/{path}
+//	list:       GET    {root}/{folder}/
+//	update:     PUT    {root}/{created path}, or a create if none exist yet
+//	create:     POST   {root}/{folder}/{new name}, or an update once
+//	            maxCreated exist
+//	delete:     DELETE {root}/{created path}, or a create if none exist yet
+//	permission: GET    {root}/permissions/check?path={path}&action=read
+//
+// A created path is only written to once observe sees its create succeed.
+// Reads stand in for writes while every secret is still being created.
+func (t *targetGenerator) nextTarget(tgt *vegeta.Target) {
+	ix := fastrand.Intn(len(t.paths))
+	secretPath := t.paths[ix]
+
+	op := t.workload.pick()
+	if op == opCreate && len(t.created)+t.creating >= maxCreated {
+		op = opUpdate
+	}
+	if (op == opUpdate || op == opDelete) && len(t.created) == 0 {
+		op = opCreate
+		if t.creating >= maxCreated {
+			op = opRead
+		}
+	}
+	switch op {
+	case opList:
+		tgt.Method, tgt.URL, tgt.Header = "GET", t.url(path.Dir(secretPath)+"/", t.listRoutes[ix]), t.header(false)
+	case opUpdate:
+		created := t.created[fastrand.Intn(len(t.created))]
+		tgt.Method, tgt.URL, tgt.Header, tgt.Body = "PUT", t.url(created, t.routes[op]), t.header(true), t.body()
+	case opCreate:
+		created := path.Join(path.Dir(secretPath), "load-"+randomString(12))
+		t.creating++
+		tgt.Method, tgt.URL, tgt.Header, tgt.Body = "POST", t.url(created, t.routes[op]), t.header(true), t.body()
+	case opDelete:
+		last := len(t.created) - 1
+		deleted := t.created[last]
+		t.created = t.created[:last]
+		tgt.Method, tgt.URL, tgt.Header = "DELETE", t.url(deleted, t.routes[op]), t.header(false)
+	case opPermission:
+		tgt.Method, tgt.URL, tgt.Header = "GET", t.url("permissions/check?action=read&path="+url.QueryEscape(secretPath), t.routes[op]), t.header(false)
+	default:
+		tgt.Method, tgt.URL, tgt.Header = "GET", t.url(secretPath, t.readRoutes[ix]), t.header(false)
+	}
+}
+
+// observe tracks the secrets created by the attack. It is told the status of
+// each labelled request, 0 if it failed, and adds the path of every create
+// that succeeded to those that updates and deletes pick from.
+func (t *targetGenerator) observe(req *http.Request, code int) {
+	if req.Method != "POST" || req.URL.Fragment != opCreate {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.creating > 0 {
+		t.creating--
+	}
+	if code >= 200 && code < 300 && len(t.created) < maxCreated {
+		t.created = append(t.created, strings.TrimPrefix(req.URL.Path, t.rootPath))
+	}
+}
+
+// url builds the url of p, labelled with its route
+func (t *targetGenerator) url(p, route string) string {
+	return t.root + "/" + p + route
+}
+
+// body generates secret data of the configured size. bodyChars never need
+// escaping so the JSON is written directly.
+func (t *targetGenerator) body() []byte {
+	body := make([]byte, 0, t.bodySize+12)
+	body = append(body, `{"data":"`...)
+	for ix := 0; ix < t.bodySize; ix++ {
+		body = append(body, bodyChars[fastrand.Intn(len(bodyChars))])
+	}
+	return append(body, `"}`...)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	for ix := range b {
+		b[ix] = bodyChars[fastrand.Intn(len(bodyChars))]
+	}
+	return string(b)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+func TestWorkloadPicksInProportion(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		want    map[string]float64
+	}{
+		{"default", nil, map[string]float64{opRead: 1}},
+		{"single", map[string]int{opList: 3}, map[string]float64{opList: 1}},
+		{"even", map[string]int{opRead: 1, opCreate: 1}, map[string]float64{opRead: 0.5, opCreate: 0.5}},
+		{
+			name:    "weighted",
+			weights: map[string]int{opRead: 6, opList: 1, opUpdate: 2, opPermission: 1},
+			want:    map[string]float64{opRead: 0.6, opList: 0.1, opUpdate: 0.2, opPermission: 0.1},
+		},
+		{
+			name:    "zero weights are never picked",
+			weights: map[string]int{opRead: 3, opDelete: 0, opCreate: 1},
+			want:    map[string]float64{opRead: 0.75, opCreate: 0.25},
+		},
+	}
+	const picks = 100000
+	for _, test := range tests {
+		w, err := newWorkload(test.weights)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		counts := map[string]int{}
+		for i := 0; i < picks; i++ {
+			counts[w.pick()]++
+		}
+		for op, count := range counts {
+			if _, ok := test.want[op]; !ok {
+				t.Errorf("%s: picked %s %d times, want never", test.name, op, count)
+			}
+		}
+		for op, want := range test.want {
+			if got := float64(counts[op]) / picks; math.Abs(got-want) > 0.01 {
+				t.Errorf("%s: picked %s %.3f of the time, want %.3f", test.name, op, got, want)
+			}
+		}
+	}
+}
+
+func TestNewWorkloadRejectsBadWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+	}{
+		{"negative", map[string]int{opRead: 1, opCreate: -1}},
+		{"unknown", map[string]int{opRead: 1, "patch": 1}},
+		{"all zero", map[string]int{opRead: 0, opList: 0}},
+	}
+	for _, test := range tests {
+		if _, err := newWorkload(test.weights); err == nil {
+			t.Errorf("%s: workload %v accepted", test.name, test.weights)
+		}
+	}
+}
+
+func TestWritesOnlyTargetCreatedSecrets(t *testing.T) {
+	mix, err := newWorkload(map[string]int{opUpdate: 1, opDelete: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := newTargetGenerator("https://tenant.example.com/", []string{"/folder/secret"}, newTokenPool([]string{"token"}), mix, 8)
+	var target vegeta.Target
+	send := func(code int) {
+		g.nextTarget(&target)
+		req, err := http.NewRequest(target.Method, target.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.observe(req, code)
+	}
+
+	tests := []struct {
+		name string
+		// the status of the response to the target
+		code   int
+		method string
+	}{
+		// nothing is created yet, so updates and deletes create instead
+		{"failed create", 500, "POST"},
+		{"create without a response", 0, "POST"},
+		{"create", 201, "POST"},
+		// the one secret created is then updated or deleted
+		{"write to the created secret", 200, ""},
+	}
+	for _, test := range tests {
+		send(test.code)
+		if test.method != "" && target.Method != test.method {
+			t.Errorf("%s: sent %s, want %s", test.name, target.Method, test.method)
+		}
+	}
+	if target.Method != "PUT" && target.Method != "DELETE" {
+		t.Fatalf("wrote with %s, want an update or delete", target.Method)
+	}
+	if !strings.HasPrefix(target.URL, "https://tenant.example.com//folder/load-") {
+		t.Errorf("wrote to %s, not the created secret", target.URL)
+	}
+}
+
+func TestCreatedSecretsAreCapped(t *testing.T) {
+	mix, err := newWorkload(map[string]int{opCreate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := newTargetGenerator("https://tenant.example.com/", []string{"/folder/secret"}, newTokenPool([]string{"token"}), mix, 8)
+	var target vegeta.Target
+	methods := map[string]int{}
+	for i := 0; i < maxCreated+10; i++ {
+		g.nextTarget(&target)
+		methods[target.Method]++
+	}
+	// every create is still in flight, so there is nothing to update yet
+	if methods["POST"] != maxCreated || methods["GET"] != 10 {
+		t.Fatalf("sent %v with every create in flight, want %d creates then reads", methods, maxCreated)
+	}
+
+	req, _ := http.NewRequest("POST", "https://tenant.example.com//folder/load-done"+routeSuffix(opCreate), nil)
+	g.observe(req, 201)
+	g.nextTarget(&target)
+	if target.Method != "PUT" {
+		t.Fatalf("sent %s at the cap, want an update", target.Method)
+	}
+	if len(g.created) != 1 || g.created[0] != "folder/load-done" {
+		t.Fatalf("created %v, want the one create that succeeded", g.created)
+	}
+}
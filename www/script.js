@@ -92,15 +92,6 @@ ScaleApp.prototype.refresh = function() {
     }
     this.refreshInProgress = true;
     var promises = [];
-    promises.push(this.http.get("/api/v1/namespaces/default/services/aggregator:8080/proxy")
-    .success(function(data) {
-	    this.fullData = data;
-	}.bind(this))
-    .error(function(data) {
-	    console.log("Error!");
-	    console.log(data);
-	}));
-
     promises.push(this.http.get("/api/v1/pods?labelSelector=run=nginx")
     .success(function(data) {
 	    this.servers = data;
@@ -122,6 +113,55 @@ ScaleApp.prototype.refresh = function() {
     this.q.all(promises).then(doneFn, doneFn);
 };
 
+// Fill the charts with the aggregator's history so they survive a reload,
+// then follow the snapshots it pushes
+ScaleApp.prototype.loadHistory = function() {
+    this.http.get("/api/v1/namespaces/default/services/aggregator:8080/proxy/history")
+    .success(function(points) {
+	    if (!points) {
+		return;
+	    }
+	    angular.forEach(points.slice(-limit), function(point) {
+		    this.lastTime = Date.parse(point.time);
+		    var success = (1 - point.errorRate) * 100;
+		    this.qpsData[0] = this.slideWindow(this.qpsData[0], point.rate);
+		    this.latencyData[0] = this.slideWindow(this.latencyData[0], point.mean / 1000000);
+		    this.latencyData[1] = this.slideWindow(this.latencyData[1], point.p99 / 1000000);
+		    this.availData[0] = this.slideWindow(this.availData[0], success);
+		    this.availData[1] = this.slideWindow(this.availData[1], 100 - success);
+		}, this);
+	}.bind(this))
+    .error(function(data) {
+	    console.log("Error loading history");
+	    console.log(data);
+	})
+    .finally(this.subscribe.bind(this));
+};
+
+// Subscribe to the snapshot the aggregator pushes after every aggregation.
+// The browser reconnects by itself if the stream drops.
+ScaleApp.prototype.subscribe = function() {
+    var source = new EventSource("/api/v1/namespaces/default/services/aggregator:8080/proxy/events");
+    source.addEventListener("snapshot", function(e) {
+	    var snapshot = JSON.parse(e.data);
+	    var time = Date.parse(snapshot.time);
+	    // the first snapshot may already be the last point of the history
+	    if (this.lastTime && time <= this.lastTime) {
+		return;
+	    }
+	    this.lastTime = time;
+	    this.scope.$apply(function() {
+		    this.fullData = snapshot.loadbots;
+		    this.fleetData = snapshot.fleet;
+		    this.updateGraphData();
+		}.bind(this));
+	}.bind(this));
+    source.onerror = function(e) {
+	console.log("Error on event stream");
+	console.log(e);
+    };
+};
+
 ScaleApp.prototype.getServerCount = function() {
     if (!this.servers || !this.servers.items) {
 	return 0;
@@ -227,6 +267,14 @@ ScaleApp.prototype.getSuccess = function() {
 };
 
 ScaleApp.prototype.getLatency = function() {
+    // percentiles of the loadbots can't be averaged, the aggregator reads
+    // them off their merged histograms
+    if (this.fleetData && this.fleetData.latencies && this.fleetData.requests) {
+	return {
+	    "mean": this.fleetData.latencies.mean / 1000000,
+	    "99th": this.fleetData.latencies["99th"] / 1000000
+	};
+    }
     if (!this.fullData) {
 	return {};
     }
@@ -269,8 +317,8 @@ ScaleApp.prototype.getNumPods = function(pods) {
 
 app.controller('AppCtrl', ['$scope', '$http', '$interval', '$q', function($scope, $http, $interval, $q) {
     $scope.controller = new ScaleApp($http, $scope, $q);
+    $scope.controller.loadHistory();
     $scope.controller.refresh();
 
     $interval($scope.controller.refresh.bind($scope.controller), 1000) 
-    $interval($scope.controller.updateGraphData.bind($scope.controller), 1000) 
 }]);